@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// fileInode returns 0 where the platform has no inode numbers (Windows), so
+// a restart only notices rotation when the file is shorter than the offset.
+func fileInode(info os.FileInfo) uint64 {
+    return 0
+}
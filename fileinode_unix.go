@@ -0,0 +1,19 @@
+//go:build unix
+
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+// fileInode returns the inode number of a file.
+func fileInode(info os.FileInfo) uint64 {
+    if info == nil {
+        return 0
+    }
+    if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+        return uint64(stat.Ino)
+    }
+    return 0
+}
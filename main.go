@@ -1,44 +1,169 @@
 package main
 
 import (
+    "bufio"
     "bytes"
+    "crypto/rand"
+    "crypto/sha1"
+    "encoding/hex"
     "encoding/json"
+    "errors"
     "fmt"
     "io"
     "mime/multipart"
     "net/http"
     "net/url"
     "os"
+    "os/exec"
+    "os/signal"
     "path"
+    "path/filepath"
+    "regexp"
+    "runtime"
+    "sort"
     "strconv"
     "strings"
+    "sync"
+    "syscall"
+    "text/tabwriter"
+    "text/template"
     "time"
+    "unicode/utf8"
 
     "github.com/spf13/cobra"
+    "gopkg.in/yaml.v3"
 )
 
 const (
     configFileName         = "slack.config.json"
     emojiFileName          = "slack.emoji.json"
+    stateFileName          = "slack.state.json"
     slackUploadURL         = "https://slack.com/api/files.getUploadURLExternal"
     slackCompleteUploadURL = "https://slack.com/api/files.completeUploadExternal"
-    slackChannelsListURL = "https://slack.com/api/conversations.list"
 )
 
+// slackAPIBaseURL is a variable so tests can point it at a local server.
+var slackAPIBaseURL = "https://slack.com/api/"
+
 var buildTime string
 
+var allConversationTypes = []string{"public_channel", "private_channel", "im", "mpim"}
+
 type Config struct {
-    SlackBotToken    string            `json:"slack_bot_token"`
-    SlackUserToken   string            `json:"slack_user_token"`
-    ChannelID        string            `json:"channel_id"`
-    UserCache        map[string]string `json:"user_cache"`
-    ChannelCache     map[string]string `json:"channel_cache"`
-    DefaultShowLimit int               `json:"default_show_limit"`
-    DefaultEmoji     string            `json:"default_emoji"`
+    SlackBotToken    string                  `json:"slack_bot_token"`
+    SlackUserToken   string                  `json:"slack_user_token"`
+    ChannelID        string                  `json:"channel_id"`
+    UserCache        map[string]string       `json:"user_cache"`
+    UserHandles      map[string]string       `json:"user_handles,omitempty"`
+    ChannelCache     map[string]string       `json:"channel_cache"`
+    DefaultShowLimit int                     `json:"default_show_limit"`
+    DefaultEmoji     string                  `json:"default_emoji"`
+    RecentChannels   []string                `json:"recent_channels,omitempty"`
+    StatusPresets    map[string]StatusPreset `json:"status_presets,omitempty"`
+    CalendarRules    []CalendarRule          `json:"calendar_rules,omitempty"`
+    TimeZone         string                  `json:"time_zone,omitempty"`
+    Templates        map[string]TemplateText `json:"templates,omitempty"`
+}
+
+type StatusPreset struct {
+    Emoji    string `json:"emoji"`
+    Text     string `json:"text"`
+    Duration string `json:"duration,omitempty"`
+}
+
+// CalendarRule maps events whose summary contains Keyword to a status. A rule
+// without a keyword applies to all other events. An empty Text uses the
+// event summary.
+type CalendarRule struct {
+    Keyword string `json:"keyword"`
+    Emoji   string `json:"emoji"`
+    Text    string `json:"text"`
+    DND     bool   `json:"dnd"`
+}
+
+// State holds data the CLI keeps between runs. Unlike the config file it is
+// not meant to be edited by hand.
+type State struct {
+    CalendarStatus *CalendarStatusState         `json:"calendar_status,omitempty"`
+    Jobs           map[string]*JobState         `json:"jobs,omitempty"`
+    FileWatches    map[string]*FileWatchState   `json:"file_watches,omitempty"`
+    Dedupe         map[string]*DedupeState      `json:"dedupe,omitempty"`
+    Outbox         []*OutboxEntry               `json:"outbox,omitempty"`
+    Schedules      map[string]*ScheduleRunState `json:"schedules,omitempty"`
+}
+
+// ScheduleRunState is the last time a scheduler entry ran and the scheduled
+// times of runs whose post failed, which are retried on the next check.
+type ScheduleRunState struct {
+    LastRun int64   `json:"last_run"`
+    Retry   []int64 `json:"retry,omitempty"`
+}
+
+// OutboxEntry is a message sent with send --queue that has not been
+// delivered yet.
+type OutboxEntry struct {
+    ID        string `json:"id"`
+    ChannelID string `json:"channel_id"`
+    ThreadTS  string `json:"thread_ts,omitempty"`
+    Text      string `json:"text"`
+    CreatedAt int64  `json:"created_at"`
+    Attempts  int    `json:"attempts"`
+    LastError string `json:"last_error,omitempty"`
+    Claimed   int64  `json:"claimed,omitempty"`
+}
+
+// DedupeState tracks the message posted for a send --dedupe-key.
+type DedupeState struct {
+    ChannelID string `json:"channel_id"`
+    Ts        string `json:"ts"`
+    Text      string `json:"text"`
+    Count     int    `json:"count"`
+    FirstSeen int64  `json:"first_seen"`
+    LastSeen  int64  `json:"last_seen"`
+}
+
+// FileWatchState is the position of watch-file in a log file. Inode
+// identifies the file the offset belongs to, so a file replaced while
+// watch-file was not running is read from the start.
+type FileWatchState struct {
+    Offset   int64  `json:"offset"`
+    Inode    uint64 `json:"inode,omitempty"`
+    ThreadTS string `json:"thread_ts,omitempty"`
+}
+
+type JobState struct {
+    ChannelID string    `json:"channel_id"`
+    Ts        string    `json:"ts"`
+    Title     string    `json:"title"`
+    Started   int64     `json:"started"`
+    Steps     []JobStep `json:"steps,omitempty"`
+}
+
+type JobStep struct {
+    Text   string `json:"text"`
+    Failed bool   `json:"failed,omitempty"`
+    Time   int64  `json:"time"`
+}
+
+// CalendarStatusState is what the last status sync set. DND is true only
+// when that sync snoozed notifications, so a later sync knows the snooze is
+// its own to end.
+type CalendarStatusState struct {
+    EventKey string `json:"event_key"`
+    Summary  string `json:"summary"`
+    Emoji    string `json:"emoji"`
+    Text     string `json:"text"`
+    End      int64  `json:"end"`
+    DND      bool   `json:"dnd"`
 }
 
 var config Config
 var emojiList map[string]string
+var state State
+
+// targetChannelID overrides config.ChannelID for a single invocation when the
+// global --channel flag is given. It is never written back to the config file.
+var targetChannelID string
 
 func loadConfig() error {
     configFile, err := os.Open(configFileName)
@@ -94,6 +219,47 @@ func saveConfig() error {
     return nil
 }
 
+// loadState reads the state file. A missing file is an empty state.
+func loadState() error {
+    state = State{}
+    stateFile, err := os.Open(stateFileName)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("could not open state file: %v", err)
+    }
+    defer stateFile.Close()
+
+    err = json.NewDecoder(stateFile).Decode(&state)
+    if err != nil {
+        return fmt.Errorf("could not decode state JSON: %v", err)
+    }
+
+    return nil
+}
+
+// saveState writes the state file through a temporary file so an interrupted
+// write never leaves it truncated.
+func saveState() error {
+    stateBytes, err := json.MarshalIndent(state, "", "    ")
+    if err != nil {
+        return fmt.Errorf("could not marshal state JSON: %v", err)
+    }
+
+    tempFileName := stateFileName + ".tmp"
+    err = os.WriteFile(tempFileName, stateBytes, 0600)
+    if err != nil {
+        return fmt.Errorf("could not write to state file: %v", err)
+    }
+    err = os.Rename(tempFileName, stateFileName)
+    if err != nil {
+        return fmt.Errorf("could not replace state file: %v", err)
+    }
+
+    return nil
+}
+
 func createConfig() error {
     config = Config{
         ChannelID:       "your_channel_id",
@@ -105,6 +271,7 @@ func createConfig() error {
         ChannelCache:     make(map[string]string),
         DefaultShowLimit: 20,
         DefaultEmoji:     "white-check-mark",
+        StatusPresets:    defaultStatusPresets(),
     }
 
     return saveConfig()
@@ -194,6 +361,84 @@ type GetUploadURLResponse struct {
     FileID    string `json:"file_id"`
 }
 
+type SlackUser struct {
+    ID       string `json:"id"`
+    Name     string `json:"name"`
+    RealName string `json:"real_name"`
+    Deleted  bool   `json:"deleted"`
+    IsBot    bool   `json:"is_bot"`
+    TZ       string `json:"tz"`
+    Profile  struct {
+        DisplayName      string `json:"display_name"`
+        RealName         string `json:"real_name"`
+        Title            string `json:"title"`
+        Email            string `json:"email"`
+        StatusText       string `json:"status_text"`
+        StatusEmoji      string `json:"status_emoji"`
+        StatusExpiration int64  `json:"status_expiration"`
+    } `json:"profile"`
+}
+
+// displayName is the name stored in the user cache.
+func (u SlackUser) displayName() string {
+    if u.RealName != "" {
+        return u.RealName
+    }
+    if u.Profile.RealName != "" {
+        return u.Profile.RealName
+    }
+    return u.Name
+}
+
+type SlackConversation struct {
+    ID         string `json:"id"`
+    Name       string `json:"name"`
+    User       string `json:"user"`
+    IsIM       bool   `json:"is_im"`
+    IsMpim     bool   `json:"is_mpim"`
+    IsPrivate  bool   `json:"is_private"`
+    IsArchived bool   `json:"is_archived"`
+    IsMember   bool   `json:"is_member"`
+    NumMembers int    `json:"num_members"`
+    Created    int64  `json:"created"`
+    Topic      struct {
+        Value string `json:"value"`
+    } `json:"topic"`
+    Purpose struct {
+        Value string `json:"value"`
+    } `json:"purpose"`
+}
+
+// displayName is the name stored in the channel cache: the channel name, or
+// the members' handles for direct messages (see directMessageCacheName).
+func (c SlackConversation) displayName(handles map[string]string) string {
+    if c.IsIM {
+        if handle, exists := handles[c.User]; exists {
+            return directMessageCacheName([]string{handle})
+        }
+        return "@" + c.User
+    }
+    if c.IsMpim {
+        return mpimDisplayName(c.Name)
+    }
+    return c.Name
+}
+
+func (c SlackConversation) typeName() string {
+    typeName := "public"
+    if c.IsIM {
+        typeName = "im"
+    } else if c.IsMpim {
+        typeName = "mpim"
+    } else if c.IsPrivate {
+        typeName = "private"
+    }
+    if c.IsArchived {
+        typeName += " (archived)"
+    }
+    return typeName
+}
+
 type UserProfile struct {
     OK   bool `json:"ok"`
     User struct {
@@ -206,43 +451,18 @@ func getChannelList() (map[string]string, error) {
         return config.ChannelCache, nil
     }
 
-    apiURL := slackChannelsListURL + "?limit=1000"
-    req, _ := http.NewRequest("GET", apiURL, nil)
-    req.Header.Set("Authorization", "Bearer "+config.SlackBotToken)
-
-    fmt.Println("apiURL:", apiURL)
-    client := &http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        return nil, fmt.Errorf("error fetching channel list: %v", err)
-    }
-    defer resp.Body.Close()
-
-    body, err := io.ReadAll(resp.Body)
+    conversations, err := listConversations(allConversationTypes, false)
     if err != nil {
-        return nil, fmt.Errorf("error reading channel list response: %v", err)
-    }
-
-    var response struct {
-        OK       bool `json:"ok"`
-        Channels []struct {
-            ID   string `json:"id"`
-            Name string `json:"name"`
-        } `json:"channels"`
+        return nil, err
     }
 
-    err = json.Unmarshal(body, &response)
+    handles, err := directMessageHandles(conversations)
     if err != nil {
-        return nil, fmt.Errorf("error decoding channel list JSON: %v", err)
-    }
-
-    if !response.OK {
-        return nil, fmt.Errorf("failed to fetch channel list")
+        return nil, err
     }
-
     channelCache := make(map[string]string)
-    for _, channel := range response.Channels {
-        channelCache[channel.ID] = channel.Name
+    for _, conversation := range conversations {
+        channelCache[conversation.ID] = conversation.displayName(handles)
     }
 
     config.ChannelCache = channelCache
@@ -254,699 +474,1158 @@ func getChannelList() (map[string]string, error) {
     return channelCache, nil
 }
 
+// listConversations pages through conversations.list for the given types
+// (public_channel, private_channel, im, mpim).
+func listConversations(types []string, includeArchived bool) ([]SlackConversation, error) {
+    var conversations []SlackConversation
+    cursor := ""
+    for {
+        params := url.Values{}
+        params.Set("limit", "1000")
+        params.Set("types", strings.Join(types, ","))
+        params.Set("exclude_archived", strconv.FormatBool(!includeArchived))
+        if cursor != "" {
+            params.Set("cursor", cursor)
+        }
 
-func getUserName(userID string, userCache map[string]string) string {
-    if userID == "" {
-        return "Unknown"
+        var response struct {
+            Channels         []SlackConversation `json:"channels"`
+            ResponseMetadata struct {
+                NextCursor string `json:"next_cursor"`
+            } `json:"response_metadata"`
+        }
+        err := slackAPIGet("conversations.list", params, config.SlackUserToken, &response)
+        if err != nil {
+            return nil, fmt.Errorf("error fetching channel list: %v", err)
+        }
+        conversations = append(conversations, response.Channels...)
+
+        cursor = response.ResponseMetadata.NextCursor
+        if cursor == "" {
+            break
+        }
     }
+    return conversations, nil
+}
 
-    if name, exists := userCache[userID]; exists {
-        return name
+// parseConversationTypes maps the short names accepted by --types to the
+// conversations.list type names.
+func parseConversationTypes(value string) ([]string, error) {
+    var types []string
+    for _, name := range strings.Split(value, ",") {
+        switch strings.TrimSpace(name) {
+        case "public", "public_channel":
+            types = append(types, "public_channel")
+        case "private", "private_channel":
+            types = append(types, "private_channel")
+        case "im":
+            types = append(types, "im")
+        case "mpim":
+            types = append(types, "mpim")
+        case "":
+        default:
+            return nil, fmt.Errorf("unknown channel type %q, use public, private, im or mpim", name)
+        }
     }
-    if name, exists := config.UserCache[userID]; exists {
-        userCache[userID] = name
-        return name
+    if len(types) == 0 {
+        return allConversationTypes, nil
     }
+    return types, nil
+}
 
-    apiURL := fmt.Sprintf("https://slack.com/api/users.info?user=%s", userID)
-    req, _ := http.NewRequest("GET", apiURL, nil)
-    req.Header.Set("Authorization", "Bearer "+config.SlackBotToken)
-
-    client := &http.Client{}
-    resp, err := client.Do(req)
+// printChannelTable lists conversations as a table and merges them into the
+// channel cache.
+func printChannelTable(types []string, includeArchived, memberOnly bool, sortBy string) error {
+    conversations, err := listConversations(types, includeArchived)
     if err != nil {
-        fmt.Println("Error fetching user profile:", err)
-        return "Unknown"
+        return err
     }
-    defer resp.Body.Close()
 
-    var userProfile UserProfile
-    body, err := io.ReadAll(resp.Body)
+    handles, err := directMessageHandles(conversations)
     if err != nil {
-        fmt.Println("Error reading user profile response:", err)
-        return "Unknown"
+        return err
     }
-
-    err = json.Unmarshal(body, &userProfile)
+    if config.ChannelCache == nil {
+        config.ChannelCache = make(map[string]string)
+    }
+    var rows []SlackConversation
+    for _, conversation := range conversations {
+        config.ChannelCache[conversation.ID] = conversation.displayName(handles)
+        if memberOnly && !conversation.IsMember && !conversation.IsIM && !conversation.IsMpim {
+            continue
+        }
+        rows = append(rows, conversation)
+    }
+    err = saveConfig()
     if err != nil {
-        fmt.Println("Error decoding user profile JSON:", err)
-        return "Unknown"
+        return fmt.Errorf("error saving config file: %v", err)
     }
 
-    if userProfile.OK {
-        name := userProfile.User.RealName
-        userCache[userID] = name
-        config.UserCache[userID] = name
-        saveConfig()
-        return name
-    } else {
-        fmt.Println("Error in response. Response body:", string(body))
-        return "Unknown"
+    switch sortBy {
+    case "", "name":
+        sort.Slice(rows, func(i, j int) bool {
+            return strings.ToLower(config.ChannelCache[rows[i].ID]) < strings.ToLower(config.ChannelCache[rows[j].ID])
+        })
+    case "members":
+        sort.Slice(rows, func(i, j int) bool {
+            return rows[i].NumMembers > rows[j].NumMembers
+        })
+    case "created":
+        sort.Slice(rows, func(i, j int) bool {
+            return rows[i].Created > rows[j].Created
+        })
+    default:
+        return fmt.Errorf("invalid sort %q, use name, members or created", sortBy)
+    }
+
+    writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(writer, " \tID\tNAME\tTYPE\tMEMBERS\tMEMBER\tTOPIC")
+    for _, conversation := range rows {
+        current := " "
+        if conversation.ID == config.ChannelID {
+            current = "*"
+        }
+        member := "no"
+        if conversation.IsMember || conversation.IsIM || conversation.IsMpim {
+            member = "yes"
+        }
+        members := ""
+        if !conversation.IsIM {
+            members = strconv.Itoa(conversation.NumMembers)
+        }
+        topic := strings.ReplaceAll(conversation.Topic.Value, "\n", " ")
+        if len([]rune(topic)) > 50 {
+            topic = string([]rune(topic)[:50]) + "..."
+        }
+        fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", current, conversation.ID, config.ChannelCache[conversation.ID], conversation.typeName(), members, member, topic)
     }
+    writer.Flush()
+    fmt.Printf("%d channels\n", len(rows))
 
-    return "Unknown"
+    return nil
 }
 
-func sendMessage(message, threadTS string) error {
-    slackMessage := map[string]string{
-        "channel": config.ChannelID,
-        "text":    message,
-    }
 
-    if threadTS != "" {
-        slackMessage["thread_ts"] = threadTS
+func currentChannelID() string {
+    if targetChannelID != "" {
+        return targetChannelID
     }
+    return config.ChannelID
+}
 
-    payload, _ := json.Marshal(slackMessage)
-
-    req, _ := http.NewRequest("POST", "https://slack.com/api/chat.postMessage", bytes.NewBuffer(payload))
-    req.Header.Set("Content-Type", "application/json; charset=utf-8")
-    req.Header.Set("Authorization", "Bearer "+config.SlackUserToken)
+// resolveChannel turns a channel ID, #name, plain name or @user into a
+// conversation ID. Names are looked up in the channel cache, refreshing it
+// once if the name is unknown; @user opens (or reuses) a direct message.
+func resolveChannel(target string) (string, error) {
+    target = strings.TrimSpace(target)
+    if target == "" {
+        return "", fmt.Errorf("channel is required")
+    }
 
-    client := &http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        fmt.Println("Error sending message:", err)
-        return err
+    if strings.HasPrefix(target, "@") {
+        return resolveDirectMessage(target)
     }
-    defer resp.Body.Close()
 
-    body, err := io.ReadAll(resp.Body)
-    if err != nil {
-        fmt.Println("Error reading response body:", err)
-        return err
+    if !strings.HasPrefix(target, "#") && looksLikeSlackID(target, "CGD") {
+        return target, nil
     }
 
-    var response struct {
-        OK    bool   `json:"ok"`
-        Error string `json:"error"`
+    name := strings.TrimPrefix(target, "#")
+    for id, cachedName := range config.ChannelCache {
+        if cachedName == name {
+            return id, nil
+        }
     }
 
-    err = json.Unmarshal(body, &response)
+    config.ChannelCache = nil
+    channelCache, err := getChannelList()
     if err != nil {
-        fmt.Println("Error unmarshaling response:", err)
-        return err
+        return "", err
     }
-
-    if !response.OK {
-        fmt.Println("Error in response. Response body:", string(body))
-        return fmt.Errorf("failed to send message: %s", response.Error)
+    for id, cachedName := range channelCache {
+        if cachedName == name {
+            return id, nil
+        }
     }
-
-    fmt.Println("Message sent successfully")
-    return nil
+    if suggestions := channelSuggestions(name, channelCache); suggestions != "" {
+        return "", fmt.Errorf("channel %s not found, did you mean: %s", target, suggestions)
+    }
+    return "", fmt.Errorf("channel %s not found", target)
 }
 
-
-func fetchMessages(limit int, dateRange, search, filter string, showFilesOnly bool) {
-    var cursor string
-    var hasMore bool
-
-    if dateRange == "" {
-        hasMore = false
-    } else {
-        hasMore = true
+// looksLikeSlackID reports whether s has the shape of a Slack object ID
+// starting with one of the given prefix letters (e.g. "CGD" for conversations).
+func looksLikeSlackID(s, prefixes string) bool {
+    if len(s) < 9 || !strings.ContainsRune(prefixes, rune(s[0])) {
+        return false
     }
-
-    var oldest, latest string
-    if dateRange != "" {
-        var oldestInt, latestInt int64
-        dates := strings.Split(dateRange, ":")
-        if len(dates) == 1 {
-            parsedDate, err := time.Parse("2006-01-02", dates[0])
-            if err != nil {
-                fmt.Println("Invalid date format. Use YYYY-MM-DD.")
-                return
-            }
-            oldestInt = parsedDate.Unix()
-            latestInt = parsedDate.AddDate(0, 0, 1).Unix() - 1
-        } else if len(dates) == 2 {
-            parsedStartDate, err := time.Parse("2006-01-02", dates[0])
-            if err != nil {
-                fmt.Println("Invalid start date format. Use YYYY-MM-DD.")
-                return
-            }
-            parsedEndDate, err := time.Parse("2006-01-02", dates[1])
-            if err != nil {
-                fmt.Println("Invalid end date format. Use YYYY-MM-DD.")
-                return
-            }
-            oldestInt = parsedStartDate.Unix()
-            latestInt = parsedEndDate.AddDate(0, 0, 1).Unix() - 1
-        } else {
-            fmt.Println("Invalid date range format. Use YYYY-MM-DD or YYYY-MM-DD:YYYY-MM-DD.")
-            return
+    for _, r := range s {
+        if !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') {
+            return false
         }
-        oldest = strconv.FormatInt(oldestInt, 10)
-        latest = strconv.FormatInt(latestInt, 10)
     }
+    return true
+}
 
-    userCache := make(map[string]string)
-    for k, v := range config.UserCache {
-        userCache[k] = v
+// resolveDirectMessage opens or reuses the DM for "@alice", or the group DM
+// for a comma-separated list such as "@alice,@bob". Opened conversations are
+// remembered in the channel cache under their "@" name.
+func resolveDirectMessage(target string) (string, error) {
+    var names []string
+    for _, name := range strings.Split(target, ",") {
+        name = strings.TrimPrefix(strings.TrimSpace(name), "@")
+        if name != "" {
+            names = append(names, name)
+        }
+    }
+    if len(names) == 0 {
+        return "", fmt.Errorf("user is required")
     }
 
-    var messages []string
-    indent := strings.Repeat(" ", 40)
-    redColorStart := "\033[91m"
-    resetColor := "\033[0m"
-    defaultColorStart := "\033[39m"
-
-    totalFetched := 0
-
-    for {
-        apiURL := fmt.Sprintf("https://slack.com/api/conversations.history?channel=%s&limit=%d", config.ChannelID, limit)
-        if oldest != "" && latest != "" {
-            apiURL = fmt.Sprintf("%s&oldest=%s&latest=%s", apiURL, oldest, latest)
+    selfHandle := ""
+    if len(names) > 1 {
+        var err error
+        selfHandle, err = getAuthUserHandle()
+        if err != nil {
+            return "", err
         }
-        if cursor != "" {
-            apiURL = fmt.Sprintf("%s&cursor=%s", apiURL, cursor)
+    }
+    cacheName := func(handles []string) string {
+        if selfHandle != "" {
+            handles = append(handles, selfHandle)
+        }
+        return directMessageCacheName(handles)
+    }
+    findCached := func(name string) string {
+        for id, cachedName := range config.ChannelCache {
+            if cachedName == name {
+                return id
+            }
         }
+        return ""
+    }
 
-        req, _ := http.NewRequest("GET", apiURL, nil)
-        req.Header.Set("Authorization", "Bearer "+config.SlackBotToken)
+    // Names are usually handles already, which avoids fetching the user list.
+    if id := findCached(cacheName(names)); id != "" {
+        return id, nil
+    }
 
-        client := &http.Client{}
-        resp, err := client.Do(req)
+    users, err := userList()
+    if err != nil {
+        return "", err
+    }
+    userIDs := make([]string, 0, len(names))
+    handles := make([]string, 0, len(names))
+    for _, name := range names {
+        userID, err := findUserID(name)
         if err != nil {
-            fmt.Println("Error getting messages:", err)
-            return
+            return "", err
         }
-        defer resp.Body.Close()
-
-        body, err := io.ReadAll(resp.Body)
-        if err != nil {
-            fmt.Println("Error reading response body:", err)
-            return
+        handle := userID
+        for _, user := range users {
+            if user.ID == userID {
+                handle = user.Name
+            }
         }
+        userIDs = append(userIDs, userID)
+        handles = append(handles, handle)
+    }
+    displayName := cacheName(handles)
+    if id := findCached(displayName); id != "" {
+        return id, nil
+    }
 
-        var messagesResponse SlackMessagesResponse
-        err = json.Unmarshal(body, &messagesResponse)
-        if err != nil {
-            fmt.Println("Error unmarshaling response:", err)
-            return
-        }
-
-        if !messagesResponse.OK {
-            fmt.Println("Error in response. Response body:", string(body))
-            return
-        }
-
-        for i, j := 0, len(messagesResponse.Messages)-1; i < j; i, j = i+1, j-1 {
-            messagesResponse.Messages[i], messagesResponse.Messages[j] = messagesResponse.Messages[j], messagesResponse.Messages[i]
-        }
-
-        for _, msg := range messagesResponse.Messages {
-            if totalFetched >= limit {
-                hasMore = false
-                break
-            }
-            if filter == "" || strings.Contains(msg.Text, filter) {
-                if showFilesOnly && len(msg.Files) == 0 {
-                    continue
-                }
-                userName := getUserName(msg.UserID, userCache)
-                edited := ""
-                if msg.Edited.User != "" {
-                    edited = " (edited)"
-                }
-                reactions := getReactionsString(msg.Reactions)
-                textLines := strings.Split(msg.Text, "\n")
-                for i, line := range textLines {
-                    if search != "" {
-                        line = strings.ReplaceAll(line, search, fmt.Sprintf("%s%s%s%s", redColorStart, search, resetColor, defaultColorStart))
-                    } else if strings.Contains(msg.Text, filter) {
-                        line = strings.ReplaceAll(line, filter, fmt.Sprintf("%s%s%s%s", redColorStart, filter, resetColor, defaultColorStart))
-                    }
-                    if i == 0 {
-                        messages = append(messages, fmt.Sprintf("%s (%s) %s: %s%s%s%s\n", msg.Ts, formatTimestamp(msg.Ts), userName, defaultColorStart, line, edited, reactions))
-                    } else {
-                        messages = append(messages, fmt.Sprintf("%s%s%s\n", indent, defaultColorStart, line))
-                    }
-                }
-                for _, file := range msg.Files {
-                    fileNameColor := "\033[94m"
-                    if strings.HasPrefix(file.Mimetype, "image/") {
-                        fileNameColor = "\033[91m"
-                    }
-                    fileEntry := fmt.Sprintf("  - File: %s%s%s (\033[36m%s\033[0m)\n", fileNameColor, file.Name, resetColor, file.URLPrivate)
-                    if search != "" {
-                        fileEntry = fmt.Sprintf("  - File: %s (%s)\n", file.Name, file.URLPrivate)
-                    }
-                    messages = append(messages, fileEntry)
-                }
-                if msg.ThreadTS != "" {
-                    replies, err := getThreadReplies(msg.ThreadTS, filter, search, userCache)
-                    if err == nil {
-                        for _, reply := range replies {
-                            replyUserName := getUserName(reply.UserID, userCache)
-                            edited = ""
-                            if reply.Edited.User != "" {
-                                edited = " (edited)"
-                            }
-                            reactions = getReactionsString(reply.Reactions)
-                            textLines = strings.Split(reply.Text, "\n")
-                            for i, line := range textLines {
-                                if search != "" {
-                                    line = strings.ReplaceAll(line, search, fmt.Sprintf("%s%s%s%s", redColorStart, search, resetColor, defaultColorStart))
-                                }
-                                if i == 0 {
-                                    messages = append(messages, fmt.Sprintf("  ↳ %s (%s) %s: %s%s%s%s\n", reply.Ts, formatTimestamp(reply.Ts), replyUserName, defaultColorStart, line, edited, reactions))
-                                } else {
-                                    messages = append(messages, fmt.Sprintf("%s%s%s\n", indent, defaultColorStart, line))
-                                }
-                            }
-                            for _, file := range reply.Files {
-                                fileNameColor := "\033[94m"
-                                if strings.HasPrefix(file.Mimetype, "image/") {
-                                    fileNameColor = "\033[91m"
-                                }
-                                fileEntry := fmt.Sprintf("    - File: %s%s%s (\033[36m%s\033[0m)\n", fileNameColor, file.Name, resetColor, file.URLPrivate)
-                                if search != "" {
-                                    fileEntry = fmt.Sprintf("    - File: %s (%s)\n", file.Name, file.URLPrivate)
-                                }
-                                messages = append(messages, fileEntry)
-                            }
-                        }
-                    }
-                }
-                totalFetched++
-            }
-        }
-
-        hasMore = messagesResponse.HasMore && cursor != "" && dateRange != ""
-        cursor = messagesResponse.ResponseMetadata.NextCursor
-
-        if !hasMore {
-            break
-        }
+    channelID, err := openConversation(userIDs)
+    if err != nil {
+        return "", err
     }
 
-    for i := 0; i < len(messages); i++ {
-        fmt.Print(messages[i])
+    if config.ChannelCache == nil {
+        config.ChannelCache = make(map[string]string)
+    }
+    config.ChannelCache[channelID] = displayName
+    err = saveConfig()
+    if err != nil {
+        return "", fmt.Errorf("error saving config file: %v", err)
     }
+    return channelID, nil
 }
 
-func formatTimestamp(ts string) string {
-    tsFloat, _ := strconv.ParseFloat(ts, 64)
-    timeStamp := time.Unix(int64(tsFloat), 0)
-    return timeStamp.Format("2006-01-02 15:04:05")
+// isDirectMessage reports whether a conversation is an IM or MPIM, which
+// have to be read with the user token.
+func isDirectMessage(channelID string) bool {
+    return strings.HasPrefix(channelID, "D") || strings.HasPrefix(config.ChannelCache[channelID], "@")
 }
 
-func getThreadReplies(threadTs, filter, search string, userCache map[string]string) ([]SlackMessageReply, error) {
-    apiURL := fmt.Sprintf("https://slack.com/api/conversations.replies?channel=%s&ts=%s", config.ChannelID, threadTs)
-    req, _ := http.NewRequest("GET", apiURL, nil)
-    req.Header.Set("Authorization", "Bearer "+config.SlackBotToken)
+// historyToken returns the token used to read the current conversation.
+func historyToken() string {
+    if isDirectMessage(currentChannelID()) {
+        return config.SlackUserToken
+    }
+    return config.SlackBotToken
+}
 
-    client := &http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        return nil, err
+// findUserID resolves a user ID, handle, display name or real name to a
+// user ID. Handles win over names. The cache is tried first, then the user
+// list, which is fetched at most once per command. A name matching several
+// users is an error listing them.
+func findUserID(name string) (string, error) {
+    if looksLikeSlackID(name, "UW") {
+        return name, nil
+    }
+    if ids := cachedUserIDs(name); len(ids) == 1 {
+        return ids[0], nil
     }
-    defer resp.Body.Close()
 
-    body, err := io.ReadAll(resp.Body)
+    users, err := userList()
     if err != nil {
-        return nil, fmt.Errorf("error reading response body: %v", err)
+        return "", err
     }
+    return userIDFromList(name, users)
+}
 
-    var threadResponse struct {
-        OK       bool                `json:"ok"`
-        Messages []SlackMessageItem  `json:"messages"`
-        Error    string              `json:"error"`
+// userIDFromList resolves a user ID, handle, display name or real name to a
+// user ID using users, failing when the name matches several users.
+func userIDFromList(name string, users []SlackUser) (string, error) {
+    if looksLikeSlackID(name, "UW") {
+        return name, nil
     }
-    err = json.Unmarshal(body, &threadResponse)
-    if err != nil {
-        return nil, fmt.Errorf("error unmarshaling response: %v", err)
+    matches := matchUsers(name, users)
+    if len(matches) == 0 {
+        return "", fmt.Errorf("user %s not found", name)
     }
-
-    if !threadResponse.OK {
-        fmt.Println("Error in response. Response body:", string(body))
-        return nil, fmt.Errorf("failed to fetch thread replies: %s", threadResponse.Error)
+    if len(matches) > 1 {
+        var labels []string
+        for _, user := range matches {
+            labels = append(labels, fmt.Sprintf("%s (@%s, %s)", user.displayName(), user.Name, user.ID))
+        }
+        return "", fmt.Errorf("%s matches several users: %s; use a handle or user ID", name, strings.Join(labels, ", "))
     }
+    return matches[0].ID, nil
+}
 
-    var replies []SlackMessageReply
-    for _, msg := range threadResponse.Messages {
-        if msg.Ts != threadTs {
-            if filter == "" || strings.Contains(msg.Text, filter) {
-                replies = append(replies, SlackMessageReply{
-                    UserID:    msg.UserID,
-                    UserName:  getUserName(msg.UserID, userCache),
-                    Text:      msg.Text,
-                    Ts:        msg.Ts,
-                    Reactions: msg.Reactions,
-                    Files:     msg.Files,
-                })
-            }
+// cachedUserIDs returns the IDs of active users whose cached handle is name
+// or, failing that, whose cached real name is name.
+func cachedUserIDs(name string) []string {
+    var byHandle, byName []string
+    for id, handle := range config.UserHandles {
+        if strings.EqualFold(handle, name) {
+            byHandle = append(byHandle, id)
+        } else if strings.EqualFold(config.UserCache[id], name) {
+            byName = append(byName, id)
         }
     }
-
-    return replies, nil
+    if len(byHandle) > 0 {
+        byName = byHandle
+    }
+    sort.Strings(byName)
+    return byName
 }
 
-
-func getReactionsString(reactions []SlackReaction) string {
-    var reactionsStr string
-    for _, reaction := range reactions {
-        emoji, exists := emojiList[reaction.Name]
-        if (!exists) {
-            emoji = reaction.Name
+// matchUsers returns the active users whose handle is name or, when no
+// handle matches, whose display name or real name is name.
+func matchUsers(name string, users []SlackUser) []SlackUser {
+    var byHandle, byName []SlackUser
+    for _, user := range users {
+        if user.Deleted {
+            continue
+        }
+        if strings.EqualFold(user.Name, name) {
+            byHandle = append(byHandle, user)
+        } else if strings.EqualFold(user.Profile.DisplayName, name) || strings.EqualFold(user.RealName, name) {
+            byName = append(byName, user)
         }
-        emojiUnicode, _ := unicodeToEmoji(emoji)
-        reactionsStr += fmt.Sprintf(" %s:%d", emojiUnicode, reaction.Count)
     }
-    return reactionsStr
+    if len(byHandle) > 0 {
+        return byHandle
+    }
+    return byName
 }
 
-func uploadFile(filePath string) {
-    file, err := os.Open(filePath)
-    if err != nil {
-        fmt.Println("Error opening file:", err)
-        return
+// fetchedUsers is the user list fetched by this command, if any.
+var fetchedUsers []SlackUser
+
+// userList returns the user list, fetching it only the first time it is
+// needed in a command.
+func userList() ([]SlackUser, error) {
+    if fetchedUsers != nil {
+        return fetchedUsers, nil
     }
-    defer file.Close()
+    return fetchUserList()
+}
 
-    fileInfo, _ := file.Stat()
-    fileSize := fileInfo.Size()
-    fileSizeStr := strconv.FormatInt(fileSize, 10)
+// fetchUserList pages through users.list and refreshes the user cache. The
+// config is only written when the cache changed.
+func fetchUserList() ([]SlackUser, error) {
+    var users []SlackUser
+    cursor := ""
+    for {
+        params := url.Values{}
+        params.Set("limit", "200")
+        if cursor != "" {
+            params.Set("cursor", cursor)
+        }
 
-    var b bytes.Buffer
-    writer := multipart.NewWriter(&b)
-    writer.WriteField("filename", fileInfo.Name())
-    writer.WriteField("length", fileSizeStr)
-    writer.WriteField("channels", config.ChannelID)
-    writer.WriteField("token", config.SlackBotToken)
-    writer.Close()
+        var response struct {
+            Members          []SlackUser `json:"members"`
+            ResponseMetadata struct {
+                NextCursor string `json:"next_cursor"`
+            } `json:"response_metadata"`
+        }
+        err := slackAPIGet("users.list", params, config.SlackBotToken, &response)
+        if err != nil {
+            return nil, fmt.Errorf("error fetching user list: %v", err)
+        }
+        users = append(users, response.Members...)
 
-    req, _ := http.NewRequest("POST", slackUploadURL, &b)
-    req.Header.Set("Content-Type", writer.FormDataContentType())
+        cursor = response.ResponseMetadata.NextCursor
+        if cursor == "" {
+            break
+        }
+    }
 
-    client := &http.Client{}
-    resp, _ := client.Do(req)
+    fetchedUsers = users
 
-    body, _ := io.ReadAll(resp.Body)
+    if config.UserCache == nil {
+        config.UserCache = make(map[string]string)
+    }
+    handles := make(map[string]string)
+    changed := false
+    for _, user := range users {
+        if config.UserCache[user.ID] != user.displayName() {
+            config.UserCache[user.ID] = user.displayName()
+            changed = true
+        }
+        if !user.Deleted {
+            handles[user.ID] = user.Name
+            if config.UserHandles[user.ID] != user.Name {
+                changed = true
+            }
+        }
+    }
+    if len(handles) != len(config.UserHandles) {
+        changed = true
+    }
+    config.UserHandles = handles
+    if !changed {
+        return users, nil
+    }
+    err := saveConfig()
+    if err != nil {
+        return nil, fmt.Errorf("error saving config file: %v", err)
+    }
 
-    var uploadURLResponse GetUploadURLResponse
-    json.NewDecoder(bytes.NewReader(body)).Decode(&uploadURLResponse)
+    return users, nil
+}
 
-    uploadURL := uploadURLResponse.UploadURL
-    fileID := uploadURLResponse.FileID
+// directMessageCacheName is the channel cache name of a DM or group DM: the
+// members' handles, lowercased and sorted, such as "@alice" or
+// "@alice,@bob,@carol". Group DMs include the token's own user, as Slack's
+// group DM names do.
+func directMessageCacheName(handles []string) string {
+    var sorted []string
+    for _, handle := range handles {
+        sorted = append(sorted, strings.ToLower(strings.TrimPrefix(handle, "@")))
+    }
+    sort.Strings(sorted)
+    return "@" + strings.Join(sorted, ",@")
+}
 
-    var fileBuffer bytes.Buffer
-    fileWriter := multipart.NewWriter(&fileBuffer)
-    filePart, _ := fileWriter.CreateFormFile("file", fileInfo.Name())
-    io.Copy(filePart, file)
-    fileWriter.Close()
+// mpimDisplayName turns a group DM name like "mpdm-alice--bob--carol-1" into
+// its cache name "@alice,@bob,@carol".
+func mpimDisplayName(name string) string {
+    trimmed := strings.TrimPrefix(name, "mpdm-")
+    if index := strings.LastIndex(trimmed, "-"); index > 0 {
+        trimmed = trimmed[:index]
+    }
+    var members []string
+    for _, member := range strings.Split(trimmed, "--") {
+        if member != "" {
+            members = append(members, member)
+        }
+    }
+    if len(members) == 0 {
+        return name
+    }
+    return directMessageCacheName(members)
+}
 
-    uploadReq, _ := http.NewRequest("POST", uploadURL, &fileBuffer)
-    uploadReq.Header.Set("Content-Type", fileWriter.FormDataContentType())
+// directMessageHandles maps user IDs to handles for the IMs among
+// conversations. The user list is only fetched when there are IMs.
+func directMessageHandles(conversations []SlackConversation) (map[string]string, error) {
+    handles := make(map[string]string)
+    for _, conversation := range conversations {
+        if !conversation.IsIM {
+            continue
+        }
+        users, err := userList()
+        if err != nil {
+            return nil, err
+        }
+        for _, user := range users {
+            handles[user.ID] = user.Name
+        }
+        break
+    }
+    return handles, nil
+}
 
-    client.Do(uploadReq)
+// openConversation opens a direct message with the given users, or returns
+// the existing one.
+func openConversation(userIDs []string) (string, error) {
+    var response struct {
+        Channel struct {
+            ID string `json:"id"`
+        } `json:"channel"`
+    }
+    payload := map[string]string{
+        "users": strings.Join(userIDs, ","),
+    }
+    err := slackAPIPost("conversations.open", payload, config.SlackUserToken, &response)
+    if err != nil {
+        return "", err
+    }
+    return response.Channel.ID, nil
+}
 
-    completeUploadPayload := map[string]interface{}{
-        "files": []map[string]string{
-            {
-                "id": fileID,
-            },
-        },
-        "channel_id": config.ChannelID,
+func getUserName(userID string, userCache map[string]string) string {
+    if userID == "" {
+        return "Unknown"
     }
 
-    completeUploadBytes, _ := json.Marshal(completeUploadPayload)
+    if name, exists := userCache[userID]; exists {
+        return name
+    }
+    if name, exists := config.UserCache[userID]; exists {
+        userCache[userID] = name
+        return name
+    }
 
-    completeUploadReq, _ := http.NewRequest("POST", slackCompleteUploadURL, bytes.NewBuffer(completeUploadBytes))
-    completeUploadReq.Header.Set("Content-Type", "application/json")
-    completeUploadReq.Header.Set("Authorization", "Bearer "+config.SlackBotToken)
+    apiURL := fmt.Sprintf("https://slack.com/api/users.info?user=%s", userID)
+    req, _ := http.NewRequest("GET", apiURL, nil)
+    req.Header.Set("Authorization", "Bearer "+config.SlackBotToken)
 
-    completeUploadResp, err := client.Do(completeUploadReq)
+    client := &http.Client{}
+    resp, err := client.Do(req)
     if err != nil {
-        fmt.Println("Failed to complete file upload")
-        return
+        fmt.Println("Error fetching user profile:", err)
+        return "Unknown"
     }
-    defer completeUploadResp.Body.Close()
+    defer resp.Body.Close()
 
-    finalBody, err := io.ReadAll(completeUploadResp.Body)
+    var userProfile UserProfile
+    body, err := io.ReadAll(resp.Body)
     if err != nil {
-        fmt.Println("Failed to read complete upload response")
-        return
+        fmt.Println("Error reading user profile response:", err)
+        return "Unknown"
     }
 
-    var finalResponse map[string]interface{}
-    err = json.Unmarshal(finalBody, &finalResponse)
+    err = json.Unmarshal(body, &userProfile)
     if err != nil {
-        fmt.Println("Failed to decode complete upload response")
-        return
-    }
-
-    files, ok := finalResponse["files"].([]interface{})
-    if !ok || len(files) == 0 {
-        fmt.Println("File information not found in response")
-        return
+        fmt.Println("Error decoding user profile JSON:", err)
+        return "Unknown"
     }
 
-    fileInfoMap, ok := files[0].(map[string]interface{})
-    if !ok {
-        fmt.Println("File information not found in response")
-        return
+    if userProfile.OK {
+        name := userProfile.User.RealName
+        userCache[userID] = name
+        config.UserCache[userID] = name
+        saveConfig()
+        return name
+    } else {
+        fmt.Println("Error in response. Response body:", string(body))
+        return "Unknown"
     }
+}
 
-    _, fileURLExists := fileInfoMap["permalink"].(string)
-    _, fileImageURLExists := fileInfoMap["url_private"].(string)
-    if !fileURLExists || !fileImageURLExists {
-        fmt.Println("File URLs not found in response")
-        return
+func sendMessage(message, threadTS string) error {
+    _, err := postMessage(currentChannelID(), message, threadTS)
+    if err != nil {
+        fmt.Println("Error sending message:", err)
+        return err
     }
 
-    time.Sleep(2 * time.Second)
-
-    fmt.Println("File uploaded and shared to Slack")
+    fmt.Println("Message sent successfully")
+    return nil
 }
 
-func getFile(fileURL string) {
-    req, _ := http.NewRequest("GET", fileURL, nil)
-    req.Header.Set("Authorization", "Bearer "+config.SlackUserToken)
 
-    client := &http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        fmt.Println("Error getting file:", err)
-        return
-    }
-    defer resp.Body.Close()
+func fetchMessages(limit int, dateRange, search, filter string, showFilesOnly bool, before, after int) {
+    var cursor string
+    var hasMore bool
 
-    parsedURL, err := url.Parse(fileURL)
-    if err != nil {
-        fmt.Println("Error parsing URL:", err)
-        return
+    if dateRange == "" {
+        hasMore = false
+    } else {
+        hasMore = true
     }
 
-    filename := path.Base(parsedURL.Path)
-
-    if _, err := os.Stat(filename); err == nil {
-        ext := path.Ext(filename)
-        name := filename[:len(filename)-len(ext)]
-        for i := 1; ; i++ {
-            newName := fmt.Sprintf("%s(%d)%s", name, i, ext)
-            if _, err := os.Stat(newName); os.IsNotExist(err) {
-                filename = newName
-                break
+    var oldest, latest string
+    if dateRange != "" {
+        var oldestInt, latestInt int64
+        dates := strings.Split(dateRange, ":")
+        if len(dates) == 1 {
+            parsedDate, err := time.Parse("2006-01-02", dates[0])
+            if err != nil {
+                fmt.Println("Invalid date format. Use YYYY-MM-DD.")
+                return
+            }
+            oldestInt = parsedDate.Unix()
+            latestInt = parsedDate.AddDate(0, 0, 1).Unix() - 1
+        } else if len(dates) == 2 {
+            parsedStartDate, err := time.Parse("2006-01-02", dates[0])
+            if err != nil {
+                fmt.Println("Invalid start date format. Use YYYY-MM-DD.")
+                return
+            }
+            parsedEndDate, err := time.Parse("2006-01-02", dates[1])
+            if err != nil {
+                fmt.Println("Invalid end date format. Use YYYY-MM-DD.")
+                return
             }
+            oldestInt = parsedStartDate.Unix()
+            latestInt = parsedEndDate.AddDate(0, 0, 1).Unix() - 1
+        } else {
+            fmt.Println("Invalid date range format. Use YYYY-MM-DD or YYYY-MM-DD:YYYY-MM-DD.")
+            return
         }
+        oldest = strconv.FormatInt(oldestInt, 10)
+        latest = strconv.FormatInt(latestInt, 10)
     }
 
-    outFile, err := os.Create(filename)
-    if err != nil {
-        fmt.Println("Error creating file:", err)
+    userCache := make(map[string]string)
+    for k, v := range config.UserCache {
+        userCache[k] = v
+    }
+
+    // With context the limit counts matches, which can take several pages.
+    if filter != "" && (before > 0 || after > 0) {
+        timeline, err := fetchContextTimeline(limit, oldest, latest, filter, showFilesOnly, before)
+        if err != nil {
+            fmt.Println("Error getting messages:", err)
+            return
+        }
+        for _, message := range renderWithContext(timeline, search, filter, before, after, limit, userCache) {
+            fmt.Print(message)
+        }
         return
     }
-    defer outFile.Close()
 
-    io.Copy(outFile, resp.Body)
-    fmt.Println("File downloaded successfully:", filename)
-}
+    var messages []string
+    totalFetched := 0
 
-func addReaction(ts string, emoji string) error {
-    if ts == "" {
-        return fmt.Errorf("Error: ts (timestamp) is required")
-    }
+    for {
+        apiURL := fmt.Sprintf("https://slack.com/api/conversations.history?channel=%s&limit=%d", currentChannelID(), limit)
+        if oldest != "" && latest != "" {
+            apiURL = fmt.Sprintf("%s&oldest=%s&latest=%s", apiURL, oldest, latest)
+        }
+        if cursor != "" {
+            apiURL = fmt.Sprintf("%s&cursor=%s", apiURL, cursor)
+        }
 
-    if emoji == "" {
-        if config.DefaultEmoji != "" {
-            emoji = config.DefaultEmoji
-        } else {
-            emoji = "white_check_mark"
+        req, _ := http.NewRequest("GET", apiURL, nil)
+        req.Header.Set("Authorization", "Bearer "+historyToken())
+
+        client := &http.Client{}
+        resp, err := client.Do(req)
+        if err != nil {
+            fmt.Println("Error getting messages:", err)
+            return
         }
-    }
+        defer resp.Body.Close()
 
-    payload := map[string]string{
-        "channel":   config.ChannelID,
-        "name":      emoji,
-        "timestamp": ts,
-    }
+        body, err := io.ReadAll(resp.Body)
+        if err != nil {
+            fmt.Println("Error reading response body:", err)
+            return
+        }
 
-    payloadBytes, _ := json.Marshal(payload)
+        var messagesResponse SlackMessagesResponse
+        err = json.Unmarshal(body, &messagesResponse)
+        if err != nil {
+            fmt.Println("Error unmarshaling response:", err)
+            return
+        }
 
-    req, _ := http.NewRequest("POST", "https://slack.com/api/reactions.add", bytes.NewBuffer(payloadBytes))
-    req.Header.Set("Content-Type", "application/json")
-    req.Header.Set("Authorization", "Bearer "+config.SlackBotToken)
+        if !messagesResponse.OK {
+            fmt.Println("Error in response. Response body:", string(body))
+            return
+        }
 
-    client := &http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        return fmt.Errorf("Error adding reaction: %v", err)
-    }
-    defer resp.Body.Close()
+        for i, j := 0, len(messagesResponse.Messages)-1; i < j; i, j = i+1, j-1 {
+            messagesResponse.Messages[i], messagesResponse.Messages[j] = messagesResponse.Messages[j], messagesResponse.Messages[i]
+        }
 
-    body, err := io.ReadAll(resp.Body)
-    if err != nil {
-        return fmt.Errorf("Error reading response body: %v", err)
-    }
+        for _, msg := range messagesResponse.Messages {
+            if totalFetched >= limit {
+                hasMore = false
+                break
+            }
+            if filter == "" || strings.Contains(msg.Text, filter) {
+                if showFilesOnly && len(msg.Files) == 0 {
+                    continue
+                }
+                messages = append(messages, renderMessage(msg, search, filter, userCache)...)
+                totalFetched++
+            }
+        }
 
-    var response struct {
-        OK    bool   `json:"ok"`
-        Error string `json:"error"`
-    }
+        hasMore = messagesResponse.HasMore && cursor != "" && dateRange != ""
+        cursor = messagesResponse.ResponseMetadata.NextCursor
 
-    err = json.Unmarshal(body, &response)
-    if err != nil {
-        return fmt.Errorf("Error unmarshaling response: %v", err)
+        if !hasMore {
+            break
+        }
     }
 
-    if !response.OK {
-        fmt.Println("Error in response. Response body:", string(body))
-        return fmt.Errorf("Failed to add reaction: %s", response.Error)
+    for i := 0; i < len(messages); i++ {
+        fmt.Print(messages[i])
     }
+}
 
-    fmt.Println("Reaction added successfully")
-    return nil
+func renderMessage(msg SlackMessageItem, search, filter string, userCache map[string]string) []string {
+    var messages []string
+    indent := strings.Repeat(" ", 40)
+    redColorStart := "\033[91m"
+    resetColor := "\033[0m"
+    defaultColorStart := "\033[39m"
+
+    userName := getUserName(msg.UserID, userCache)
+    edited := ""
+    if msg.Edited.User != "" {
+        edited = " (edited)"
+    }
+    reactions := getReactionsString(msg.Reactions)
+    textLines := strings.Split(msg.Text, "\n")
+    for i, line := range textLines {
+        if search != "" {
+            line = strings.ReplaceAll(line, search, fmt.Sprintf("%s%s%s%s", redColorStart, search, resetColor, defaultColorStart))
+        } else if strings.Contains(msg.Text, filter) {
+            line = strings.ReplaceAll(line, filter, fmt.Sprintf("%s%s%s%s", redColorStart, filter, resetColor, defaultColorStart))
+        }
+        if i == 0 {
+            messages = append(messages, fmt.Sprintf("%s (%s) %s: %s%s%s%s\n", msg.Ts, formatTimestamp(msg.Ts), userName, defaultColorStart, line, edited, reactions))
+        } else {
+            messages = append(messages, fmt.Sprintf("%s%s%s\n", indent, defaultColorStart, line))
+        }
+    }
+    for _, file := range msg.Files {
+        fileNameColor := "\033[94m"
+        if strings.HasPrefix(file.Mimetype, "image/") {
+            fileNameColor = "\033[91m"
+        }
+        fileEntry := fmt.Sprintf("  - File: %s%s%s (\033[36m%s\033[0m)\n", fileNameColor, file.Name, resetColor, file.URLPrivate)
+        if search != "" {
+            fileEntry = fmt.Sprintf("  - File: %s (%s)\n", file.Name, file.URLPrivate)
+        }
+        messages = append(messages, fileEntry)
+    }
+    if msg.ThreadTS != "" {
+        replies, err := getThreadReplies(msg.ThreadTS, filter, search, userCache)
+        if err == nil {
+            for _, reply := range replies {
+                replyUserName := getUserName(reply.UserID, userCache)
+                edited = ""
+                if reply.Edited.User != "" {
+                    edited = " (edited)"
+                }
+                reactions = getReactionsString(reply.Reactions)
+                textLines = strings.Split(reply.Text, "\n")
+                for i, line := range textLines {
+                    if search != "" {
+                        line = strings.ReplaceAll(line, search, fmt.Sprintf("%s%s%s%s", redColorStart, search, resetColor, defaultColorStart))
+                    }
+                    if i == 0 {
+                        messages = append(messages, fmt.Sprintf("  ↳ %s (%s) %s: %s%s%s%s\n", reply.Ts, formatTimestamp(reply.Ts), replyUserName, defaultColorStart, line, edited, reactions))
+                    } else {
+                        messages = append(messages, fmt.Sprintf("%s%s%s\n", indent, defaultColorStart, line))
+                    }
+                }
+                for _, file := range reply.Files {
+                    fileNameColor := "\033[94m"
+                    if strings.HasPrefix(file.Mimetype, "image/") {
+                        fileNameColor = "\033[91m"
+                    }
+                    fileEntry := fmt.Sprintf("    - File: %s%s%s (\033[36m%s\033[0m)\n", fileNameColor, file.Name, resetColor, file.URLPrivate)
+                    if search != "" {
+                        fileEntry = fmt.Sprintf("    - File: %s (%s)\n", file.Name, file.URLPrivate)
+                    }
+                    messages = append(messages, fileEntry)
+                }
+            }
+        }
+    }
+    return messages
 }
 
+// fetchContextTimeline pages back through the history, oldest first in the
+// result, until it holds the newest limit messages matching filter plus
+// before older messages as their context. Newer context comes with the pages
+// already fetched.
+func fetchContextTimeline(limit int, oldest, latest, filter string, showFilesOnly bool, before int) ([]SlackMessageItem, error) {
+    var fetched []SlackMessageItem
+    matches, context := 0, 0
+    cursor := ""
+    for matches < limit || context < before {
+        params := url.Values{}
+        params.Set("channel", currentChannelID())
+        params.Set("limit", "200")
+        if oldest != "" && latest != "" {
+            params.Set("oldest", oldest)
+            params.Set("latest", latest)
+        }
+        if cursor != "" {
+            params.Set("cursor", cursor)
+        }
+        var response SlackMessagesResponse
+        err := slackAPIGet("conversations.history", params, historyToken(), &response)
+        if err != nil {
+            return nil, err
+        }
 
-func removeReaction(ts string, emoji string) error {
-    if ts == "" {
-        return fmt.Errorf("Error: ts (timestamp) is required")
+        // Pages are newest first.
+        for _, msg := range response.Messages {
+            if showFilesOnly && len(msg.Files) == 0 {
+                continue
+            }
+            if matches == limit {
+                if context == before {
+                    break
+                }
+                context++
+            } else if strings.Contains(msg.Text, filter) {
+                matches++
+            }
+            fetched = append(fetched, msg)
+        }
+
+        cursor = response.ResponseMetadata.NextCursor
+        if !response.HasMore || cursor == "" {
+            break
+        }
     }
 
-    if emoji == "" {
-        emoji = "white_check_mark"
+    for i, j := 0, len(fetched)-1; i < j; i, j = i+1, j-1 {
+        fetched[i], fetched[j] = fetched[j], fetched[i]
     }
+    return fetched, nil
+}
 
-    payload := map[string]string{
-        "channel":   config.ChannelID,
-        "name":      emoji,
-        "timestamp": ts,
+// renderWithContext prints the newest limit messages matching filter
+// together with the given number of surrounding messages, separating
+// non-adjacent groups with "--" the same way grep -C does. Older matches
+// are only shown as context.
+func renderWithContext(timeline []SlackMessageItem, search, filter string, before, after, limit int, userCache map[string]string) []string {
+    matchMarker := "\033[1;91m>\033[0m "
+    contextMarker := "  "
+
+    // Index of the oldest match that counts towards the limit.
+    first := len(timeline)
+    for i, found := len(timeline)-1, 0; i >= 0 && found < limit; i-- {
+        if strings.Contains(timeline[i].Text, filter) {
+            first = i
+            found++
+        }
+    }
+    isMatch := func(i int) bool {
+        return i >= first && strings.Contains(timeline[i].Text, filter)
     }
 
-    payloadBytes, _ := json.Marshal(payload)
+    var messages []string
+    lastPrinted := -1
+    for i := range timeline {
+        if !isMatch(i) {
+            continue
+        }
 
-    req, _ := http.NewRequest("POST", "https://slack.com/api/reactions.remove", bytes.NewBuffer(payloadBytes))
-    req.Header.Set("Content-Type", "application/json")
-    req.Header.Set("Authorization", "Bearer "+config.SlackBotToken)
+        start := i - before
+        if start <= lastPrinted {
+            start = lastPrinted + 1
+        }
+        if start < 0 {
+            start = 0
+        }
+        if lastPrinted >= 0 && start > lastPrinted+1 {
+            messages = append(messages, "--\n")
+        }
+
+        end := i + after
+        if end >= len(timeline) {
+            end = len(timeline) - 1
+        }
+        for j := start; j <= end; j++ {
+            if j <= lastPrinted {
+                continue
+            }
+            rendered := renderMessage(timeline[j], search, filter, userCache)
+            if len(rendered) > 0 {
+                if isMatch(j) {
+                    rendered[0] = matchMarker + rendered[0]
+                } else {
+                    rendered[0] = contextMarker + rendered[0]
+                }
+            }
+            messages = append(messages, rendered...)
+            lastPrinted = j
+        }
+    }
+
+    return messages
+}
+
+func formatTimestamp(ts string) string {
+    tsFloat, _ := strconv.ParseFloat(ts, 64)
+    timeStamp := time.Unix(int64(tsFloat), 0)
+    return timeStamp.Format("2006-01-02 15:04:05")
+}
+
+func getThreadReplies(threadTs, filter, search string, userCache map[string]string) ([]SlackMessageReply, error) {
+    apiURL := fmt.Sprintf("https://slack.com/api/conversations.replies?channel=%s&ts=%s", currentChannelID(), threadTs)
+    req, _ := http.NewRequest("GET", apiURL, nil)
+    req.Header.Set("Authorization", "Bearer "+historyToken())
 
     client := &http.Client{}
     resp, err := client.Do(req)
     if err != nil {
-        return fmt.Errorf("Error removing reaction: %v", err)
+        return nil, err
     }
     defer resp.Body.Close()
 
     body, err := io.ReadAll(resp.Body)
     if err != nil {
-        return fmt.Errorf("Error reading response body: %v", err)
+        return nil, fmt.Errorf("error reading response body: %v", err)
     }
 
-    var response struct {
-        OK    bool   `json:"ok"`
-        Error string `json:"error"`
+    var threadResponse struct {
+        OK       bool                `json:"ok"`
+        Messages []SlackMessageItem  `json:"messages"`
+        Error    string              `json:"error"`
     }
-
-    err = json.Unmarshal(body, &response)
+    err = json.Unmarshal(body, &threadResponse)
     if err != nil {
-        return fmt.Errorf("Error unmarshaling response: %v", err)
+        return nil, fmt.Errorf("error unmarshaling response: %v", err)
     }
 
-    if !response.OK {
+    if !threadResponse.OK {
         fmt.Println("Error in response. Response body:", string(body))
-        return fmt.Errorf("Failed to remove reaction: %s", response.Error)
+        return nil, fmt.Errorf("failed to fetch thread replies: %s", threadResponse.Error)
     }
 
-    fmt.Println("Reaction removed successfully")
-    return nil
+    var replies []SlackMessageReply
+    for _, msg := range threadResponse.Messages {
+        if msg.Ts != threadTs {
+            if filter == "" || strings.Contains(msg.Text, filter) {
+                replies = append(replies, SlackMessageReply{
+                    UserID:    msg.UserID,
+                    UserName:  getUserName(msg.UserID, userCache),
+                    Text:      msg.Text,
+                    Ts:        msg.Ts,
+                    Reactions: msg.Reactions,
+                    Files:     msg.Files,
+                })
+            }
+        }
+    }
+
+    return replies, nil
 }
 
 
-func updateMessage(ts, message string) error {
-    payload := map[string]string{
-        "channel": config.ChannelID,
-        "ts":      ts,
-        "text":    message,
+func getReactionsString(reactions []SlackReaction) string {
+    var reactionsStr string
+    for _, reaction := range reactions {
+        emoji, exists := emojiList[reaction.Name]
+        if (!exists) {
+            emoji = reaction.Name
+        }
+        emojiUnicode, _ := unicodeToEmoji(emoji)
+        reactionsStr += fmt.Sprintf(" %s:%d", emojiUnicode, reaction.Count)
+    }
+    return reactionsStr
+}
+
+func uploadFile(filePath string) {
+    file, err := os.Open(filePath)
+    if err != nil {
+        fmt.Println("Error opening file:", err)
+        return
     }
+    defer file.Close()
 
-    payloadBytes, _ := json.Marshal(payload)
+    fileInfo, _ := file.Stat()
+    fileSize := fileInfo.Size()
+    fileSizeStr := strconv.FormatInt(fileSize, 10)
 
-    req, _ := http.NewRequest("POST", "https://slack.com/api/chat.update", bytes.NewBuffer(payloadBytes))
-    req.Header.Set("Content-Type", "application/json")
-    req.Header.Set("Authorization", "Bearer "+config.SlackBotToken)
+    var b bytes.Buffer
+    writer := multipart.NewWriter(&b)
+    writer.WriteField("filename", fileInfo.Name())
+    writer.WriteField("length", fileSizeStr)
+    writer.WriteField("channels", currentChannelID())
+    writer.WriteField("token", config.SlackBotToken)
+    writer.Close()
+
+    req, _ := http.NewRequest("POST", slackUploadURL, &b)
+    req.Header.Set("Content-Type", writer.FormDataContentType())
 
     client := &http.Client{}
-    resp, err := client.Do(req)
+    resp, _ := client.Do(req)
+
+    body, _ := io.ReadAll(resp.Body)
+
+    var uploadURLResponse GetUploadURLResponse
+    json.NewDecoder(bytes.NewReader(body)).Decode(&uploadURLResponse)
+
+    uploadURL := uploadURLResponse.UploadURL
+    fileID := uploadURLResponse.FileID
+
+    var fileBuffer bytes.Buffer
+    fileWriter := multipart.NewWriter(&fileBuffer)
+    filePart, _ := fileWriter.CreateFormFile("file", fileInfo.Name())
+    io.Copy(filePart, file)
+    fileWriter.Close()
+
+    uploadReq, _ := http.NewRequest("POST", uploadURL, &fileBuffer)
+    uploadReq.Header.Set("Content-Type", fileWriter.FormDataContentType())
+
+    client.Do(uploadReq)
+
+    completeUploadPayload := map[string]interface{}{
+        "files": []map[string]string{
+            {
+                "id": fileID,
+            },
+        },
+        "channel_id": currentChannelID(),
+    }
+
+    completeUploadBytes, _ := json.Marshal(completeUploadPayload)
+
+    completeUploadReq, _ := http.NewRequest("POST", slackCompleteUploadURL, bytes.NewBuffer(completeUploadBytes))
+    completeUploadReq.Header.Set("Content-Type", "application/json")
+    completeUploadReq.Header.Set("Authorization", "Bearer "+config.SlackBotToken)
+
+    completeUploadResp, err := client.Do(completeUploadReq)
     if err != nil {
-        fmt.Println("Error updating message:", err)
-        return err
+        fmt.Println("Failed to complete file upload")
+        return
     }
-    defer resp.Body.Close()
+    defer completeUploadResp.Body.Close()
 
-    body, err := io.ReadAll(resp.Body)
+    finalBody, err := io.ReadAll(completeUploadResp.Body)
     if err != nil {
-        fmt.Println("Error reading response body:", err)
-        return err
+        fmt.Println("Failed to read complete upload response")
+        return
     }
 
-    var response struct {
-        OK    bool   `json:"ok"`
-        Error string `json:"error"`
+    var finalResponse map[string]interface{}
+    err = json.Unmarshal(finalBody, &finalResponse)
+    if err != nil {
+        fmt.Println("Failed to decode complete upload response")
+        return
     }
 
-    err = json.Unmarshal(body, &response)
+    files, ok := finalResponse["files"].([]interface{})
+    if !ok || len(files) == 0 {
+        fmt.Println("File information not found in response")
+        return
+    }
+
+    fileInfoMap, ok := files[0].(map[string]interface{})
+    if !ok {
+        fmt.Println("File information not found in response")
+        return
+    }
+
+    _, fileURLExists := fileInfoMap["permalink"].(string)
+    _, fileImageURLExists := fileInfoMap["url_private"].(string)
+    if !fileURLExists || !fileImageURLExists {
+        fmt.Println("File URLs not found in response")
+        return
+    }
+
+    time.Sleep(2 * time.Second)
+
+    fmt.Println("File uploaded and shared to Slack")
+}
+
+func getFile(fileURL string) {
+    req, _ := http.NewRequest("GET", fileURL, nil)
+    req.Header.Set("Authorization", "Bearer "+config.SlackUserToken)
+
+    client := &http.Client{}
+    resp, err := client.Do(req)
     if err != nil {
-        fmt.Println("Error unmarshaling response:", err)
-        return err
+        fmt.Println("Error getting file:", err)
+        return
     }
+    defer resp.Body.Close()
 
-    if !response.OK {
-        fmt.Println("Error in response. Response body:", string(body))
-        return fmt.Errorf("Failed to update message: %s", response.Error)
+    parsedURL, err := url.Parse(fileURL)
+    if err != nil {
+        fmt.Println("Error parsing URL:", err)
+        return
     }
 
-    fmt.Println("Message updated successfully")
-    return nil
+    filename := path.Base(parsedURL.Path)
+
+    if _, err := os.Stat(filename); err == nil {
+        ext := path.Ext(filename)
+        name := filename[:len(filename)-len(ext)]
+        for i := 1; ; i++ {
+            newName := fmt.Sprintf("%s(%d)%s", name, i, ext)
+            if _, err := os.Stat(newName); os.IsNotExist(err) {
+                filename = newName
+                break
+            }
+        }
+    }
+
+    outFile, err := os.Create(filename)
+    if err != nil {
+        fmt.Println("Error creating file:", err)
+        return
+    }
+    defer outFile.Close()
+
+    io.Copy(outFile, resp.Body)
+    fmt.Println("File downloaded successfully:", filename)
 }
 
+func addReaction(ts string, emoji string) error {
+    if ts == "" {
+        return fmt.Errorf("Error: ts (timestamp) is required")
+    }
+
+    if emoji == "" {
+        if config.DefaultEmoji != "" {
+            emoji = config.DefaultEmoji
+        } else {
+            emoji = "white_check_mark"
+        }
+    }
 
-func deleteMessage(ts string) error {
     payload := map[string]string{
-        "channel": config.ChannelID,
-        "ts":      ts,
+        "channel":   currentChannelID(),
+        "name":      emoji,
+        "timestamp": ts,
     }
 
     payloadBytes, _ := json.Marshal(payload)
 
-    req, _ := http.NewRequest("POST", "https://slack.com/api/chat.delete", bytes.NewBuffer(payloadBytes))
+    req, _ := http.NewRequest("POST", "https://slack.com/api/reactions.add", bytes.NewBuffer(payloadBytes))
     req.Header.Set("Content-Type", "application/json")
     req.Header.Set("Authorization", "Bearer "+config.SlackBotToken)
 
     client := &http.Client{}
     resp, err := client.Do(req)
     if err != nil {
-        fmt.Println("Error deleting message:", err)
-        return err
+        return fmt.Errorf("Error adding reaction: %v", err)
     }
     defer resp.Body.Close()
 
     body, err := io.ReadAll(resp.Body)
     if err != nil {
-        fmt.Println("Error reading response body:", err)
-        return err
+        return fmt.Errorf("Error reading response body: %v", err)
     }
 
     var response struct {
@@ -954,250 +1633,6325 @@ func deleteMessage(ts string) error {
         Error string `json:"error"`
     }
 
-    err = json.Unmarshal(body, &response)
-    if err != nil {
-        fmt.Println("Error unmarshaling response:", err)
-        return err
-    }
+    err = json.Unmarshal(body, &response)
+    if err != nil {
+        return fmt.Errorf("Error unmarshaling response: %v", err)
+    }
+
+    if !response.OK {
+        fmt.Println("Error in response. Response body:", string(body))
+        return fmt.Errorf("Failed to add reaction: %s", response.Error)
+    }
+
+    fmt.Println("Reaction added successfully")
+    return nil
+}
+
+
+func removeReaction(ts string, emoji string) error {
+    if ts == "" {
+        return fmt.Errorf("Error: ts (timestamp) is required")
+    }
+
+    if emoji == "" {
+        emoji = "white_check_mark"
+    }
+
+    payload := map[string]string{
+        "channel":   currentChannelID(),
+        "name":      emoji,
+        "timestamp": ts,
+    }
+
+    payloadBytes, _ := json.Marshal(payload)
+
+    req, _ := http.NewRequest("POST", "https://slack.com/api/reactions.remove", bytes.NewBuffer(payloadBytes))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Authorization", "Bearer "+config.SlackBotToken)
+
+    client := &http.Client{}
+    resp, err := client.Do(req)
+    if err != nil {
+        return fmt.Errorf("Error removing reaction: %v", err)
+    }
+    defer resp.Body.Close()
+
+    body, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return fmt.Errorf("Error reading response body: %v", err)
+    }
+
+    var response struct {
+        OK    bool   `json:"ok"`
+        Error string `json:"error"`
+    }
+
+    err = json.Unmarshal(body, &response)
+    if err != nil {
+        return fmt.Errorf("Error unmarshaling response: %v", err)
+    }
+
+    if !response.OK {
+        fmt.Println("Error in response. Response body:", string(body))
+        return fmt.Errorf("Failed to remove reaction: %s", response.Error)
+    }
+
+    fmt.Println("Reaction removed successfully")
+    return nil
+}
+
+
+func updateMessage(ts, message string) error {
+    err := editMessage(currentChannelID(), ts, message, config.SlackBotToken)
+    if err != nil {
+        fmt.Println("Error updating message:", err)
+        return err
+    }
+
+    fmt.Println("Message updated successfully")
+    return nil
+}
+
+
+func deleteMessage(ts string) error {
+    payload := map[string]string{
+        "channel": currentChannelID(),
+        "ts":      ts,
+    }
+
+    payloadBytes, _ := json.Marshal(payload)
+
+    req, _ := http.NewRequest("POST", "https://slack.com/api/chat.delete", bytes.NewBuffer(payloadBytes))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Authorization", "Bearer "+config.SlackBotToken)
+
+    client := &http.Client{}
+    resp, err := client.Do(req)
+    if err != nil {
+        fmt.Println("Error deleting message:", err)
+        return err
+    }
+    defer resp.Body.Close()
+
+    body, err := io.ReadAll(resp.Body)
+    if err != nil {
+        fmt.Println("Error reading response body:", err)
+        return err
+    }
+
+    var response struct {
+        OK    bool   `json:"ok"`
+        Error string `json:"error"`
+    }
+
+    err = json.Unmarshal(body, &response)
+    if err != nil {
+        fmt.Println("Error unmarshaling response:", err)
+        return err
+    }
+
+    if !response.OK {
+        fmt.Println("Error in response. Response body:", string(body))
+        return fmt.Errorf("Failed to delete message: %s", response.Error)
+    }
+
+    fmt.Println("Message deleted successfully")
+    return nil
+}
+
+
+func handleError(body io.Reader) error {
+    var response struct {
+        OK    bool   `json:"ok"`
+        Error string `json:"error"`
+    }
+    if err := json.NewDecoder(body).Decode(&response); err != nil {
+        return err
+    }
+    if !response.OK {
+        return fmt.Errorf(response.Error)
+    }
+    return nil
+}
+
+// slackAPIGet calls a read-only Slack Web API method with query parameters
+// and decodes the JSON response into out. A response with "ok": false is
+// returned as an error carrying Slack's error code.
+func slackAPIGet(method string, params url.Values, token string, out interface{}) error {
+    apiURL := slackAPIBaseURL + method
+    if len(params) > 0 {
+        apiURL += "?" + params.Encode()
+    }
+    req, _ := http.NewRequest("GET", apiURL, nil)
+    req.Header.Set("Authorization", "Bearer "+token)
+
+    return doSlackRequest(method, req, out)
+}
+
+// slackAPIPost calls a Slack Web API method with a JSON payload and decodes
+// the JSON response into out.
+func slackAPIPost(method string, payload interface{}, token string, out interface{}) error {
+    payloadBytes, err := json.Marshal(payload)
+    if err != nil {
+        return fmt.Errorf("error encoding %s payload: %v", method, err)
+    }
+    req, _ := http.NewRequest("POST", slackAPIBaseURL+method, bytes.NewBuffer(payloadBytes))
+    req.Header.Set("Content-Type", "application/json; charset=utf-8")
+    req.Header.Set("Authorization", "Bearer "+token)
+
+    return doSlackRequest(method, req, out)
+}
+
+func doSlackRequest(method string, req *http.Request, out interface{}) error {
+    client := &http.Client{}
+    resp, err := client.Do(req)
+    if err != nil {
+        return fmt.Errorf("error calling %s: %v", method, err)
+    }
+    defer resp.Body.Close()
+
+    body, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return fmt.Errorf("error reading %s response: %v", method, err)
+    }
+
+    var response struct {
+        OK     bool   `json:"ok"`
+        Error  string `json:"error"`
+        Needed string `json:"needed"`
+    }
+    err = json.Unmarshal(body, &response)
+    if err != nil {
+        return fmt.Errorf("error decoding %s response: %v", method, err)
+    }
+    if response.Error == "missing_scope" {
+        return fmt.Errorf("%s failed: the token is missing the %s scope; add it to the Slack app and reinstall", method, response.Needed)
+    }
+    if !response.OK {
+        return fmt.Errorf("%s failed: %s", method, response.Error)
+    }
+
+    if out != nil {
+        err = json.Unmarshal(body, out)
+        if err != nil {
+            return fmt.Errorf("error decoding %s response: %v", method, err)
+        }
+    }
+    return nil
+}
+
+func handleEmoji(ts, emoji, add, del string) error {
+    if add != "" {
+        return addReaction(ts, add)
+    } else if del != "" {
+        return removeReaction(ts, del)
+    } else {
+        if emoji == "" {
+            return listEmoji()
+        } else {
+            return addReaction(ts, emoji)
+        }
+    }
+}
+
+func listEmoji() error {
+    if len(emojiList) == 0 {
+        fmt.Println("Loading emoji list from file...")
+
+        err := loadEmojiConfig()
+        if err != nil {
+            return fmt.Errorf("Error loading emoji list from file: %v", err)
+        }
+
+        fmt.Println("Emoji list loaded successfully")
+    }
+
+    fmt.Println("Emoji List:")
+    for name, code := range emojiList {
+        emoji, err := unicodeToEmoji(code)
+        if err != nil {
+            fmt.Printf("%s: %s (failed to convert)\n", name, code)
+        } else {
+            fmt.Printf("%s: %s\n", name, emoji)
+        }
+    }
+
+    return nil
+}
+
+func unicodeToEmoji(unicodeStr string) (string, error) {
+    unicodeStr = strings.ReplaceAll(unicodeStr, "&#x", "")
+    unicodeStr = strings.ReplaceAll(unicodeStr, ";", "")
+    runeValue, err := strconv.ParseInt(unicodeStr, 16, 32)
+    if err != nil {
+        return "", err
+    }
+    return string(rune(runeValue)), nil
+}
+
+type SearchPaging struct {
+    Count int `json:"count"`
+    Total int `json:"total"`
+    Page  int `json:"page"`
+    Pages int `json:"pages"`
+}
+
+type SearchMessageMatch struct {
+    Ts        string `json:"ts"`
+    Text      string `json:"text"`
+    UserID    string `json:"user"`
+    UserName  string `json:"username"`
+    Permalink string `json:"permalink"`
+    Channel   struct {
+        ID   string `json:"id"`
+        Name string `json:"name"`
+    } `json:"channel"`
+}
+
+type SearchFileMatch struct {
+    ID        string   `json:"id"`
+    Name      string   `json:"name"`
+    Title     string   `json:"title"`
+    Timestamp int64    `json:"timestamp"`
+    UserID    string   `json:"user"`
+    Permalink string   `json:"permalink"`
+    Channels  []string `json:"channels"`
+}
+
+// searchWorkspace runs a Slack search query (in:#ops from:@bob after:2024-01-01
+// has:link ...) across the whole workspace, paging through results until limit
+// hits have been printed. sortBy is "score" or "time".
+func searchWorkspace(query string, limit int, sortBy string, asc bool, files bool) error {
+    if query == "" {
+        return fmt.Errorf("search query is required")
+    }
+
+    sortParam := "score"
+    if sortBy == "time" || sortBy == "timestamp" {
+        sortParam = "timestamp"
+    } else if sortBy != "" && sortBy != "score" {
+        return fmt.Errorf("invalid sort %q, use score or time", sortBy)
+    }
+    sortDir := "desc"
+    if asc {
+        sortDir = "asc"
+    }
+
+    method := "search.messages"
+    if files {
+        method = "search.files"
+    }
+
+    userCache := make(map[string]string)
+    for k, v := range config.UserCache {
+        userCache[k] = v
+    }
+
+    pageSize := limit
+    if pageSize > 100 || pageSize <= 0 {
+        pageSize = 100
+    }
+
+    printed := 0
+    for page := 1; ; page++ {
+        params := url.Values{}
+        params.Set("query", query)
+        params.Set("sort", sortParam)
+        params.Set("sort_dir", sortDir)
+        params.Set("count", strconv.Itoa(pageSize))
+        params.Set("page", strconv.Itoa(page))
+
+        var response struct {
+            Messages struct {
+                Total   int                  `json:"total"`
+                Paging  SearchPaging         `json:"paging"`
+                Matches []SearchMessageMatch `json:"matches"`
+            } `json:"messages"`
+            Files struct {
+                Total   int               `json:"total"`
+                Paging  SearchPaging      `json:"paging"`
+                Matches []SearchFileMatch `json:"matches"`
+            } `json:"files"`
+        }
+        err := slackAPIGet(method, params, config.SlackUserToken, &response)
+        if err != nil {
+            return err
+        }
+
+        paging := response.Messages.Paging
+        total := response.Messages.Total
+        if files {
+            paging = response.Files.Paging
+            total = response.Files.Total
+        }
+        if page == 1 {
+            fmt.Printf("%d results for %q\n", total, query)
+        }
+
+        if files {
+            for _, match := range response.Files.Matches {
+                if limit > 0 && printed >= limit {
+                    return nil
+                }
+                channelNames := make([]string, 0, len(match.Channels))
+                for _, id := range match.Channels {
+                    channelNames = append(channelNames, "#"+channelDisplayName(id))
+                }
+                fmt.Printf("%s %s %s: \033[94m%s\033[0m\n", strings.Join(channelNames, ","), time.Unix(match.Timestamp, 0).Format("2006-01-02 15:04:05"), getUserName(match.UserID, userCache), match.Name)
+                fmt.Printf("    \033[36m%s\033[0m\n", match.Permalink)
+                printed++
+            }
+        } else {
+            for _, match := range response.Messages.Matches {
+                if limit > 0 && printed >= limit {
+                    return nil
+                }
+                userName := match.UserName
+                if match.UserID != "" {
+                    userName = getUserName(match.UserID, userCache)
+                }
+                text := strings.ReplaceAll(match.Text, "\n", " ")
+                if len([]rune(text)) > 120 {
+                    text = string([]rune(text)[:120]) + "..."
+                }
+                fmt.Printf("#%s %s (%s) %s: %s\n", match.Channel.Name, match.Ts, formatTimestamp(match.Ts), userName, text)
+                fmt.Printf("    \033[36m%s\033[0m\n", match.Permalink)
+                printed++
+            }
+        }
+
+        if page >= paging.Pages {
+            return nil
+        }
+    }
+}
+
+// channelDisplayName returns the cached name for a channel ID, falling back
+// to the ID itself.
+func channelDisplayName(channelID string) string {
+    if name, exists := config.ChannelCache[channelID]; exists {
+        return name
+    }
+    return channelID
+}
+
+const maxRecentChannels = 10
+
+type channelMatch struct {
+    ID    string
+    Name  string
+    Score int
+}
+
+// fuzzyScore rates how well query matches name; higher is better and -1 means
+// no match. Exact, prefix and substring matches rank above in-order character
+// matches, which rank above names within a small edit distance.
+func fuzzyScore(query, name string) int {
+    query = strings.ToLower(strings.TrimPrefix(query, "#"))
+    name = strings.ToLower(name)
+    if query == "" {
+        return 0
+    }
+    if name == query {
+        return 1000
+    }
+    if strings.HasPrefix(name, query) {
+        return 800 - len(name)
+    }
+    if index := strings.Index(name, query); index >= 0 {
+        return 600 - index - len(name)
+    }
+
+    position, gaps := 0, 0
+    nameRunes := []rune(name)
+    matched := true
+    for _, r := range query {
+        found := false
+        for position < len(nameRunes) {
+            if nameRunes[position] == r {
+                found = true
+                position++
+                break
+            }
+            position++
+            gaps++
+        }
+        if !found {
+            matched = false
+            break
+        }
+    }
+    if matched {
+        return 400 - gaps
+    }
+
+    distance := levenshtein(query, name)
+    maxDistance := len([]rune(query)) / 3
+    if maxDistance < 2 {
+        maxDistance = 2
+    }
+    if distance <= maxDistance {
+        return 200 - distance*10
+    }
+    return -1
+}
+
+func levenshtein(a, b string) int {
+    ar, br := []rune(a), []rune(b)
+    previous := make([]int, len(br)+1)
+    current := make([]int, len(br)+1)
+    for j := range previous {
+        previous[j] = j
+    }
+    for i := 1; i <= len(ar); i++ {
+        current[0] = i
+        for j := 1; j <= len(br); j++ {
+            cost := 1
+            if ar[i-1] == br[j-1] {
+                cost = 0
+            }
+            current[j] = previous[j] + 1
+            if current[j-1]+1 < current[j] {
+                current[j] = current[j-1] + 1
+            }
+            if previous[j-1]+cost < current[j] {
+                current[j] = previous[j-1] + cost
+            }
+        }
+        previous, current = current, previous
+    }
+    return previous[len(br)]
+}
+
+// rankChannels returns the cached channels matching query, best first. With
+// an empty query, recently used channels come first, then the rest by name.
+func rankChannels(query string, channelCache map[string]string) []channelMatch {
+    recentRank := make(map[string]int)
+    for i, id := range config.RecentChannels {
+        recentRank[id] = len(config.RecentChannels) - i
+    }
+
+    var matches []channelMatch
+    for id, name := range channelCache {
+        score := fuzzyScore(query, name)
+        if score < 0 {
+            continue
+        }
+        score = score*100 + recentRank[id]
+        matches = append(matches, channelMatch{ID: id, Name: name, Score: score})
+    }
+    sort.Slice(matches, func(i, j int) bool {
+        if matches[i].Score != matches[j].Score {
+            return matches[i].Score > matches[j].Score
+        }
+        return strings.ToLower(matches[i].Name) < strings.ToLower(matches[j].Name)
+    })
+    return matches
+}
+
+// channelSuggestions formats the best few fuzzy matches for an error message.
+func channelSuggestions(query string, channelCache map[string]string) string {
+    matches := rankChannels(query, channelCache)
+    var names []string
+    for i := 0; i < len(matches) && i < 5; i++ {
+        names = append(names, matches[i].Name)
+    }
+    return strings.Join(names, ", ")
+}
+
+// recordRecentChannel moves a channel to the front of the recently used list
+// and reports whether the list changed.
+func recordRecentChannel(channelID string) bool {
+    if len(config.RecentChannels) > 0 && config.RecentChannels[0] == channelID {
+        return false
+    }
+    recent := []string{channelID}
+    for _, id := range config.RecentChannels {
+        if id != channelID && len(recent) < maxRecentChannels {
+            recent = append(recent, id)
+        }
+    }
+    config.RecentChannels = recent
+    return true
+}
+
+func setDefaultChannel(channelID string) error {
+    config.ChannelID = channelID
+    recordRecentChannel(channelID)
+    err := saveConfig()
+    if err != nil {
+        return fmt.Errorf("error saving config file: %v", err)
+    }
+    fmt.Printf("Default channel set to %s (%s)\n", channelDisplayName(channelID), channelID)
+    return nil
+}
+
+// pickChannel shows an interactive, filterable channel list and returns the
+// selected channel ID, or "" if the selection was cancelled. When the
+// terminal cannot be put into raw mode it falls back to a numbered prompt.
+func pickChannel(channelCache map[string]string) (string, error) {
+    if !stdinIsTerminal() {
+        return pickChannelByNumber(channelCache)
+    }
+    restore, err := setRawTerminal()
+    if err != nil {
+        return pickChannelByNumber(channelCache)
+    }
+    defer restore()
+
+    const visibleRows = 10
+    query := ""
+    selected := 0
+    offset := 0
+    drawn := 0
+    buf := make([]byte, 16)
+
+    for {
+        matches := rankChannels(query, channelCache)
+        if selected >= len(matches) {
+            selected = len(matches) - 1
+        }
+        if selected < 0 {
+            selected = 0
+        }
+        if selected < offset {
+            offset = selected
+        }
+        if selected >= offset+visibleRows {
+            offset = selected - visibleRows + 1
+        }
+
+        if drawn > 0 {
+            fmt.Printf("\033[%dF", drawn)
+        }
+        fmt.Printf("\r\033[JChannel (↑/↓ to move, enter to select, esc to cancel): %s", query)
+        drawn = 0
+        for i := offset; i < len(matches) && i < offset+visibleRows; i++ {
+            line := fmt.Sprintf("  %s (%s)", matches[i].Name, matches[i].ID)
+            if i == selected {
+                line = fmt.Sprintf("\033[7m> %s (%s)\033[0m", matches[i].Name, matches[i].ID)
+            }
+            fmt.Print("\r\n" + line)
+            drawn++
+        }
+        if len(matches) == 0 {
+            fmt.Print("\r\n  (no matching channels)")
+            drawn++
+        }
+
+        n, err := os.Stdin.Read(buf)
+        if err != nil {
+            return "", err
+        }
+        key := buf[:n]
+
+        switch {
+        case n == 1 && (key[0] == 3 || key[0] == 27):
+            clearPicker(drawn)
+            return "", nil
+        case n == 1 && (key[0] == '\r' || key[0] == '\n'):
+            clearPicker(drawn)
+            if len(matches) == 0 {
+                return "", nil
+            }
+            return matches[selected].ID, nil
+        case n == 1 && (key[0] == 127 || key[0] == 8):
+            if query != "" {
+                queryRunes := []rune(query)
+                query = string(queryRunes[:len(queryRunes)-1])
+                selected, offset = 0, 0
+            }
+        case n == 1 && key[0] == 16, n >= 3 && key[0] == 27 && key[2] == 'A':
+            selected--
+        case n == 1 && key[0] == 14, n >= 3 && key[0] == 27 && key[2] == 'B':
+            selected++
+        case key[0] >= 32 && key[0] != 127 && utf8.Valid(key):
+            query += string(key)
+            selected, offset = 0, 0
+        }
+    }
+}
+
+func clearPicker(drawn int) {
+    if drawn > 0 {
+        fmt.Printf("\033[%dF", drawn)
+    }
+    fmt.Print("\r\033[J")
+}
+
+func setRawTerminal() (func(), error) {
+    saved, err := runStty("-g")
+    if err != nil {
+        return nil, err
+    }
+    _, err = runStty("raw", "-echo")
+    if err != nil {
+        return nil, err
+    }
+    return func() {
+        runStty(strings.TrimSpace(saved))
+    }, nil
+}
+
+func runStty(args ...string) (string, error) {
+    cmd := exec.Command("stty", args...)
+    cmd.Stdin = os.Stdin
+    out, err := cmd.Output()
+    return string(out), err
+}
+
+func pickChannelByNumber(channelCache map[string]string) (string, error) {
+    reader := bufio.NewReader(os.Stdin)
+    query := ""
+    for {
+        matches := rankChannels(query, channelCache)
+        for i := 0; i < len(matches) && i < 20; i++ {
+            fmt.Printf("%2d) %s (%s)\n", i+1, matches[i].Name, matches[i].ID)
+        }
+        fmt.Print("Select a number, type to filter, or press enter to cancel: ")
+
+        line, err := reader.ReadString('\n')
+        line = strings.TrimSpace(line)
+        if line == "" {
+            return "", nil
+        }
+        if number, convErr := strconv.Atoi(line); convErr == nil {
+            if number >= 1 && number <= len(matches) && number <= 20 {
+                return matches[number-1].ID, nil
+            }
+            fmt.Println("Invalid selection")
+            continue
+        }
+        if err != nil {
+            return "", err
+        }
+        query = line
+    }
+}
+
+// adminChannelTarget resolves the optional channel argument of a channel
+// administration command, defaulting to the current channel.
+func adminChannelTarget(args []string) (string, error) {
+    if len(args) > 0 {
+        return resolveChannel(args[0])
+    }
+    if currentChannelID() == "" {
+        return "", fmt.Errorf("no channel given and no default channel set")
+    }
+    return currentChannelID(), nil
+}
+
+func getConversationInfo(channelID string) (SlackConversation, error) {
+    var response struct {
+        Channel SlackConversation `json:"channel"`
+    }
+    params := url.Values{}
+    params.Set("channel", channelID)
+    err := slackAPIGet("conversations.info", params, config.SlackUserToken, &response)
+    return response.Channel, err
+}
+
+// cacheChannel records a channel name in the channel cache and saves it.
+func cacheChannel(channelID, name string) error {
+    if config.ChannelCache == nil {
+        config.ChannelCache = make(map[string]string)
+    }
+    if name == "" {
+        delete(config.ChannelCache, channelID)
+    } else {
+        config.ChannelCache[channelID] = name
+    }
+    err := saveConfig()
+    if err != nil {
+        return fmt.Errorf("error saving config file: %v", err)
+    }
+    return nil
+}
+
+func createChannel(name string, private bool) error {
+    var response struct {
+        Channel SlackConversation `json:"channel"`
+    }
+    payload := map[string]interface{}{
+        "name":       strings.TrimPrefix(name, "#"),
+        "is_private": private,
+    }
+    err := slackAPIPost("conversations.create", payload, config.SlackUserToken, &response)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Channel #%s created (%s)\n", response.Channel.Name, response.Channel.ID)
+    return cacheChannel(response.Channel.ID, response.Channel.Name)
+}
+
+func archiveChannel(channelID string) error {
+    err := slackAPIPost("conversations.archive", map[string]string{"channel": channelID}, config.SlackUserToken, nil)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Channel #%s archived\n", channelDisplayName(channelID))
+    return cacheChannel(channelID, "")
+}
+
+// unarchiveChannel restores an archived channel. Archived channels are not in
+// the channel cache, so names are looked up among archived channels.
+func unarchiveChannel(target string) error {
+    channelID := ""
+    name := strings.TrimPrefix(target, "#")
+    if looksLikeSlackID(name, "CG") {
+        channelID = name
+    } else {
+        conversations, err := listConversations([]string{"public_channel", "private_channel"}, true)
+        if err != nil {
+            return err
+        }
+        for _, conversation := range conversations {
+            if conversation.Name == name {
+                channelID = conversation.ID
+                break
+            }
+        }
+        if channelID == "" {
+            return fmt.Errorf("channel %s not found", target)
+        }
+    }
+
+    err := slackAPIPost("conversations.unarchive", map[string]string{"channel": channelID}, config.SlackUserToken, nil)
+    if err != nil {
+        return err
+    }
+    info, err := getConversationInfo(channelID)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Channel #%s unarchived\n", info.Name)
+    return cacheChannel(channelID, info.Name)
+}
+
+func renameChannel(channelID, name string) error {
+    var response struct {
+        Channel SlackConversation `json:"channel"`
+    }
+    payload := map[string]string{
+        "channel": channelID,
+        "name":    strings.TrimPrefix(name, "#"),
+    }
+    err := slackAPIPost("conversations.rename", payload, config.SlackUserToken, &response)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Channel #%s renamed to #%s\n", channelDisplayName(channelID), response.Channel.Name)
+    return cacheChannel(channelID, response.Channel.Name)
+}
+
+func joinChannel(channelID string) error {
+    var response struct {
+        Channel SlackConversation `json:"channel"`
+    }
+    err := slackAPIPost("conversations.join", map[string]string{"channel": channelID}, config.SlackUserToken, &response)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Joined #%s\n", response.Channel.Name)
+    return cacheChannel(channelID, response.Channel.Name)
+}
+
+// leaveChannel leaves a channel. Private channels disappear from the channel
+// list once we leave, so they are dropped from the cache as well.
+func leaveChannel(channelID string) error {
+    info, err := getConversationInfo(channelID)
+    if err != nil {
+        return err
+    }
+    err = slackAPIPost("conversations.leave", map[string]string{"channel": channelID}, config.SlackUserToken, nil)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Left #%s\n", info.Name)
+    if info.IsPrivate {
+        return cacheChannel(channelID, "")
+    }
+    return nil
+}
+
+func inviteToChannel(channelID string, users []string) error {
+    var userIDs []string
+    for _, user := range users {
+        userID, err := findUserID(strings.TrimPrefix(user, "@"))
+        if err != nil {
+            return err
+        }
+        userIDs = append(userIDs, userID)
+    }
+    payload := map[string]string{
+        "channel": channelID,
+        "users":   strings.Join(userIDs, ","),
+    }
+    err := slackAPIPost("conversations.invite", payload, config.SlackUserToken, nil)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Invited %s to #%s\n", strings.Join(users, ", "), channelDisplayName(channelID))
+    return nil
+}
+
+func kickFromChannel(channelID string, users []string) error {
+    for _, user := range users {
+        userID, err := findUserID(strings.TrimPrefix(user, "@"))
+        if err != nil {
+            return err
+        }
+        payload := map[string]string{
+            "channel": channelID,
+            "user":    userID,
+        }
+        err = slackAPIPost("conversations.kick", payload, config.SlackUserToken, nil)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("Removed %s from #%s\n", user, channelDisplayName(channelID))
+    }
+    return nil
+}
+
+func setChannelTopic(channelID, topic string) error {
+    payload := map[string]string{
+        "channel": channelID,
+        "topic":   topic,
+    }
+    err := slackAPIPost("conversations.setTopic", payload, config.SlackUserToken, nil)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Topic of #%s set\n", channelDisplayName(channelID))
+    return nil
+}
+
+func setChannelPurpose(channelID, purpose string) error {
+    payload := map[string]string{
+        "channel": channelID,
+        "purpose": purpose,
+    }
+    err := slackAPIPost("conversations.setPurpose", payload, config.SlackUserToken, nil)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Purpose of #%s set\n", channelDisplayName(channelID))
+    return nil
+}
+
+// parseMembersFile reads a channel membership file. The file is YAML (JSON
+// is accepted too) mapping channel names to member lists:
+//
+//    ops:
+//      - alice
+//      - group:oncall
+//    "#general": [alice, bob]
+//
+// Members are user names or IDs; "group:<handle>" expands a user group. The
+// channel names are returned in file order.
+func parseMembersFile(fileName string, data []byte) (map[string][]string, []string, error) {
+    members := make(map[string][]string)
+    var order []string
+
+    var document yaml.Node
+    err := yaml.Unmarshal(data, &document)
+    if err != nil {
+        return nil, nil, fmt.Errorf("could not decode %s: %v", fileName, err)
+    }
+    if len(document.Content) == 0 {
+        return members, order, nil
+    }
+
+    root := document.Content[0]
+    if root.Kind != yaml.MappingNode {
+        return nil, nil, fmt.Errorf("%s:%d: expected a map of channels to members", fileName, root.Line)
+    }
+    for i := 0; i+1 < len(root.Content); i += 2 {
+        key, value := root.Content[i], root.Content[i+1]
+        var list []string
+        if value.Kind != yaml.ScalarNode || value.Tag != "!!null" {
+            err := value.Decode(&list)
+            if err != nil {
+                return nil, nil, fmt.Errorf("%s:%d: expected a list of members for %s", fileName, value.Line, key.Value)
+            }
+        }
+        if _, exists := members[key.Value]; !exists {
+            order = append(order, key.Value)
+        }
+        for _, member := range list {
+            member = strings.TrimSpace(member)
+            if member != "" {
+                members[key.Value] = append(members[key.Value], member)
+            }
+        }
+    }
+
+    return members, order, nil
+}
+
+// getConversationMembers pages through conversations.members.
+func getConversationMembers(channelID string) ([]string, error) {
+    var members []string
+    cursor := ""
+    for {
+        params := url.Values{}
+        params.Set("channel", channelID)
+        params.Set("limit", "1000")
+        if cursor != "" {
+            params.Set("cursor", cursor)
+        }
+
+        var response struct {
+            Members          []string `json:"members"`
+            ResponseMetadata struct {
+                NextCursor string `json:"next_cursor"`
+            } `json:"response_metadata"`
+        }
+        err := slackAPIGet("conversations.members", params, config.SlackUserToken, &response)
+        if err != nil {
+            return nil, err
+        }
+        members = append(members, response.Members...)
+
+        cursor = response.ResponseMetadata.NextCursor
+        if cursor == "" {
+            break
+        }
+    }
+    return members, nil
+}
+
+// fetchUserGroups returns the IDs of the workspace's user groups keyed by
+// lower-case handle.
+func fetchUserGroups() (map[string]string, error) {
+    var groups struct {
+        Usergroups []struct {
+            ID     string `json:"id"`
+            Handle string `json:"handle"`
+        } `json:"usergroups"`
+    }
+    err := slackAPIGet("usergroups.list", nil, config.SlackUserToken, &groups)
+    if err != nil {
+        return nil, err
+    }
+
+    groupIDs := make(map[string]string)
+    for _, group := range groups.Usergroups {
+        groupIDs[strings.ToLower(group.Handle)] = group.ID
+    }
+    return groupIDs, nil
+}
+
+// getUserGroupMembers returns the user IDs of the given user group.
+func getUserGroupMembers(groupID string) ([]string, error) {
+    var response struct {
+        Users []string `json:"users"`
+    }
+    params := url.Values{}
+    params.Set("usergroup", groupID)
+    err := slackAPIGet("usergroups.users.list", params, config.SlackUserToken, &response)
+    if err != nil {
+        return nil, err
+    }
+    return response.Users, nil
+}
+
+// getAuthUserID returns the ID of the user owning the user token.
+func getAuthUserID() (string, error) {
+    var response struct {
+        UserID string `json:"user_id"`
+    }
+    err := slackAPIGet("auth.test", nil, config.SlackUserToken, &response)
+    return response.UserID, err
+}
+
+// getAuthUserHandle returns the handle of the user owning the user token.
+func getAuthUserHandle() (string, error) {
+    var response struct {
+        User string `json:"user"`
+    }
+    err := slackAPIGet("auth.test", nil, config.SlackUserToken, &response)
+    return response.User, err
+}
+
+// syncChannelMembers compares the membership file with each channel's
+// current members and prints the plan. Changes are only made when apply is
+// set. Bots and the token owner are never removed.
+func syncChannelMembers(fileName string, apply bool) error {
+    data, err := os.ReadFile(fileName)
+    if err != nil {
+        return fmt.Errorf("could not read %s: %v", fileName, err)
+    }
+    desiredByChannel, order, err := parseMembersFile(fileName, data)
+    if err != nil {
+        return err
+    }
+
+    users, err := fetchUserList()
+    if err != nil {
+        return err
+    }
+    usersByID := make(map[string]SlackUser)
+    for _, user := range users {
+        usersByID[user.ID] = user
+    }
+    selfID, err := getAuthUserID()
+    if err != nil {
+        return err
+    }
+    userLabel := func(userID string) string {
+        if user, exists := usersByID[userID]; exists {
+            return fmt.Sprintf("%s (%s)", user.displayName(), userID)
+        }
+        return userID
+    }
+
+    if apply {
+        fmt.Println("Applying membership changes:")
+    } else {
+        fmt.Println("Membership plan (dry run, use --apply to make changes):")
+    }
+
+    var groupIDs map[string]string
+    groupMembersByID := make(map[string][]string)
+    invited, removed, failed, unchanged := 0, 0, 0, 0
+    for _, channelName := range order {
+        channelID, err := resolveChannel(channelName)
+        if err != nil {
+            fmt.Printf("#%s: %v\n", strings.TrimPrefix(channelName, "#"), err)
+            failed++
+            continue
+        }
+
+        desired := make(map[string]bool)
+        for _, entry := range desiredByChannel[channelName] {
+            if strings.HasPrefix(entry, "group:") {
+                if groupIDs == nil {
+                    groupIDs, err = fetchUserGroups()
+                    if err != nil {
+                        return err
+                    }
+                }
+                handle := strings.TrimPrefix(strings.TrimPrefix(entry, "group:"), "@")
+                groupID, exists := groupIDs[strings.ToLower(handle)]
+                if !exists {
+                    return fmt.Errorf("user group %s not found", handle)
+                }
+                groupMembers, exists := groupMembersByID[groupID]
+                if !exists {
+                    groupMembers, err = getUserGroupMembers(groupID)
+                    if err != nil {
+                        return err
+                    }
+                    groupMembersByID[groupID] = groupMembers
+                }
+                for _, userID := range groupMembers {
+                    desired[userID] = true
+                }
+                continue
+            }
+            userID, err := userIDFromList(strings.TrimPrefix(entry, "@"), users)
+            if err != nil {
+                return fmt.Errorf("#%s: %v", channelName, err)
+            }
+            desired[userID] = true
+        }
+
+        currentMembers, err := getConversationMembers(channelID)
+        if err != nil {
+            fmt.Printf("#%s: %v\n", channelDisplayName(channelID), err)
+            failed++
+            continue
+        }
+        current := make(map[string]bool)
+        for _, userID := range currentMembers {
+            current[userID] = true
+        }
+
+        var toInvite, toRemove []string
+        for userID := range desired {
+            if !current[userID] {
+                toInvite = append(toInvite, userID)
+            }
+        }
+        channelUnchanged := 0
+        for userID := range current {
+            if desired[userID] {
+                channelUnchanged++
+            } else if userID != selfID && !usersByID[userID].IsBot {
+                toRemove = append(toRemove, userID)
+            }
+        }
+        sort.Strings(toInvite)
+        sort.Strings(toRemove)
+        unchanged += channelUnchanged
+
+        fmt.Printf("#%s\n", channelDisplayName(channelID))
+        for _, userID := range toInvite {
+            status := ""
+            if apply {
+                payload := map[string]string{
+                    "channel": channelID,
+                    "users":   userID,
+                }
+                err := slackAPIPost("conversations.invite", payload, config.SlackUserToken, nil)
+                if err != nil {
+                    status = fmt.Sprintf(" failed: %v", err)
+                    failed++
+                } else {
+                    status = " done"
+                    invited++
+                }
+            } else {
+                invited++
+            }
+            fmt.Printf("  + %s%s\n", userLabel(userID), status)
+        }
+        for _, userID := range toRemove {
+            status := ""
+            if apply {
+                payload := map[string]string{
+                    "channel": channelID,
+                    "user":    userID,
+                }
+                err := slackAPIPost("conversations.kick", payload, config.SlackUserToken, nil)
+                if err != nil {
+                    status = fmt.Sprintf(" failed: %v", err)
+                    failed++
+                } else {
+                    status = " done"
+                    removed++
+                }
+            } else {
+                removed++
+            }
+            fmt.Printf("  - %s%s\n", userLabel(userID), status)
+        }
+        fmt.Printf("  = %d unchanged\n", channelUnchanged)
+    }
+
+    verb := "to invite"
+    removeVerb := "to remove"
+    if apply {
+        verb = "invited"
+        removeVerb = "removed"
+    }
+    fmt.Printf("Summary: %d channels, %d %s, %d %s, %d unchanged, %d failed\n", len(order), invited, verb, removed, removeVerb, unchanged, failed)
+    if failed > 0 {
+        return fmt.Errorf("%d operations failed", failed)
+    }
+    return nil
+}
+
+// userFlags describes account states shown next to a user.
+func userFlags(user SlackUser) string {
+    var flags []string
+    if user.IsBot {
+        flags = append(flags, "bot")
+    }
+    if user.Deleted {
+        flags = append(flags, "deactivated")
+    }
+    return strings.Join(flags, ",")
+}
+
+func userStatus(user SlackUser) string {
+    status := strings.TrimSpace(user.Profile.StatusEmoji + " " + user.Profile.StatusText)
+    if user.Profile.StatusExpiration > 0 && status != "" {
+        status += " (until " + time.Unix(user.Profile.StatusExpiration, 0).Format("2006-01-02 15:04") + ")"
+    }
+    return status
+}
+
+func getUserPresence(userID string) (string, error) {
+    var response struct {
+        Presence string `json:"presence"`
+    }
+    params := url.Values{}
+    params.Set("user", userID)
+    err := slackAPIGet("users.getPresence", params, config.SlackBotToken, &response)
+    return response.Presence, err
+}
+
+// printUserTable prints users as a table. Presence needs one API call per
+// user, so it is only looked up when requested.
+func printUserTable(users []SlackUser, withPresence bool) {
+    writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    header := "ID\tNAME\tREAL NAME\tDISPLAY NAME\tTITLE\tTIME ZONE\tSTATUS\tFLAGS"
+    if withPresence {
+        header += "\tPRESENCE"
+    }
+    fmt.Fprintln(writer, header)
+    for _, user := range users {
+        row := fmt.Sprintf("%s\t@%s\t%s\t%s\t%s\t%s\t%s\t%s", user.ID, user.Name, user.displayName(), user.Profile.DisplayName, user.Profile.Title, user.TZ, userStatus(user), userFlags(user))
+        if withPresence {
+            presence, err := getUserPresence(user.ID)
+            if err != nil {
+                presence = "unknown"
+            }
+            row += "\t" + presence
+        }
+        fmt.Fprintln(writer, row)
+    }
+    writer.Flush()
+    fmt.Printf("%d users\n", len(users))
+}
+
+func sortUsers(users []SlackUser) {
+    sort.Slice(users, func(i, j int) bool {
+        return strings.ToLower(users[i].displayName()) < strings.ToLower(users[j].displayName())
+    })
+}
+
+func listUsers(activeOnly, withPresence bool) error {
+    users, err := fetchUserList()
+    if err != nil {
+        return err
+    }
+    var rows []SlackUser
+    for _, user := range users {
+        if activeOnly && (user.Deleted || user.IsBot) {
+            continue
+        }
+        rows = append(rows, user)
+    }
+    sortUsers(rows)
+    printUserTable(rows, withPresence)
+    return nil
+}
+
+// searchUsers lists users whose handle, names, title or email contain text.
+func searchUsers(text string) error {
+    users, err := fetchUserList()
+    if err != nil {
+        return err
+    }
+    query := strings.ToLower(strings.TrimPrefix(text, "@"))
+    var rows []SlackUser
+    for _, user := range users {
+        fields := []string{user.Name, user.RealName, user.Profile.RealName, user.Profile.DisplayName, user.Profile.Title, user.Profile.Email}
+        for _, field := range fields {
+            if field != "" && strings.Contains(strings.ToLower(field), query) {
+                rows = append(rows, user)
+                break
+            }
+        }
+    }
+    sortUsers(rows)
+    printUserTable(rows, len(rows) <= 20)
+    return nil
+}
+
+func showUserInfo(target string) error {
+    userID, err := findUserID(strings.TrimPrefix(target, "@"))
+    if err != nil {
+        return err
+    }
+
+    var response struct {
+        User SlackUser `json:"user"`
+    }
+    params := url.Values{}
+    params.Set("user", userID)
+    err = slackAPIGet("users.info", params, config.SlackBotToken, &response)
+    if err != nil {
+        return err
+    }
+    user := response.User
+
+    if config.UserCache == nil {
+        config.UserCache = make(map[string]string)
+    }
+    config.UserCache[user.ID] = user.displayName()
+    err = saveConfig()
+    if err != nil {
+        return fmt.Errorf("error saving config file: %v", err)
+    }
+
+    presence, err := getUserPresence(user.ID)
+    if err != nil {
+        presence = "unknown"
+    }
+    localTime := ""
+    if location, err := time.LoadLocation(user.TZ); err == nil && user.TZ != "" {
+        localTime = time.Now().In(location).Format("2006-01-02 15:04")
+    }
+    flags := userFlags(user)
+    if flags == "" {
+        flags = "-"
+    }
+
+    writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    fmt.Fprintf(writer, "ID:\t%s\n", user.ID)
+    fmt.Fprintf(writer, "Name:\t@%s\n", user.Name)
+    fmt.Fprintf(writer, "Real name:\t%s\n", user.displayName())
+    fmt.Fprintf(writer, "Display name:\t%s\n", user.Profile.DisplayName)
+    fmt.Fprintf(writer, "Title:\t%s\n", user.Profile.Title)
+    fmt.Fprintf(writer, "Email:\t%s\n", user.Profile.Email)
+    fmt.Fprintf(writer, "Time zone:\t%s %s\n", user.TZ, localTime)
+    fmt.Fprintf(writer, "Status:\t%s\n", userStatus(user))
+    fmt.Fprintf(writer, "Presence:\t%s\n", presence)
+    fmt.Fprintf(writer, "Account:\t%s\n", flags)
+    writer.Flush()
+    return nil
+}
+
+// defaultStatusPresets are used when the config file defines no presets.
+func defaultStatusPresets() map[string]StatusPreset {
+    return map[string]StatusPreset{
+        "lunch": {Emoji: ":hamburger:", Text: "Lunch", Duration: "1h"},
+        "focus": {Emoji: ":no_bell:", Text: "Focusing", Duration: "2h"},
+    }
+}
+
+func statusPresets() map[string]StatusPreset {
+    if len(config.StatusPresets) > 0 {
+        return config.StatusPresets
+    }
+    return defaultStatusPresets()
+}
+
+// parseDuration extends time.ParseDuration with a "d" (day) unit, so values
+// like "3d" or "1d12h" are accepted.
+func parseDuration(value string) (time.Duration, error) {
+    value = strings.TrimSpace(value)
+    var days int
+    if index := strings.Index(value, "d"); index > 0 {
+        parsedDays, err := strconv.Atoi(value[:index])
+        if err != nil {
+            return 0, fmt.Errorf("invalid duration %q", value)
+        }
+        days = parsedDays
+        value = value[index+1:]
+    }
+    duration := time.Duration(0)
+    if value != "" {
+        parsed, err := time.ParseDuration(value)
+        if err != nil {
+            return 0, fmt.Errorf("invalid duration %q", value)
+        }
+        duration = parsed
+    }
+    return duration + time.Duration(days)*24*time.Hour, nil
+}
+
+// parseUntil parses "YYYY-MM-DD" (end of that day) or "YYYY-MM-DD HH:MM" in
+// local time.
+func parseUntil(value string) (time.Time, error) {
+    if parsed, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local); err == nil {
+        return parsed, nil
+    }
+    parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD or \"YYYY-MM-DD HH:MM\"", value)
+    }
+    return parsed.AddDate(0, 0, 1).Add(-time.Second), nil
+}
+
+// validateEmoji checks an emoji such as ":palm_tree:" against the emoji table
+// and suggests close names when it is unknown.
+func validateEmoji(emoji string) error {
+    name := strings.Trim(emoji, ":")
+    if index := strings.Index(name, "::"); index >= 0 {
+        name = name[:index]
+    }
+    if _, exists := emojiList[name]; exists {
+        return nil
+    }
+
+    type candidate struct {
+        name  string
+        score int
+    }
+    var candidates []candidate
+    for emojiName := range emojiList {
+        if score := fuzzyScore(name, emojiName); score >= 0 {
+            candidates = append(candidates, candidate{emojiName, score})
+        }
+    }
+    sort.Slice(candidates, func(i, j int) bool {
+        if candidates[i].score != candidates[j].score {
+            return candidates[i].score > candidates[j].score
+        }
+        return candidates[i].name < candidates[j].name
+    })
+    var suggestions []string
+    for i := 0; i < len(candidates) && i < 5; i++ {
+        suggestions = append(suggestions, ":"+candidates[i].name+":")
+    }
+    if len(suggestions) > 0 {
+        return fmt.Errorf("unknown emoji :%s:, did you mean: %s", name, strings.Join(suggestions, ", "))
+    }
+    return fmt.Errorf("unknown emoji :%s:", name)
+}
+
+// splitStatusText separates a leading ":emoji:" from the status text.
+func splitStatusText(text string) (string, string) {
+    text = strings.TrimSpace(text)
+    if strings.HasPrefix(text, ":") {
+        if end := strings.Index(text[1:], ":"); end > 0 {
+            emoji := text[:end+2]
+            if !strings.Contains(emoji, " ") {
+                return emoji, strings.TrimSpace(text[end+2:])
+            }
+        }
+    }
+    return "", text
+}
+
+// setStatus sets the user's status with users.profile.set. A zero expiration
+// keeps the status until it is cleared.
+func setStatus(emoji, text string, expiration time.Time) error {
+    if emoji != "" {
+        err := validateEmoji(emoji)
+        if err != nil {
+            return err
+        }
+        emoji = ":" + strings.Trim(emoji, ":") + ":"
+    }
+
+    var expirationUnix int64
+    if !expiration.IsZero() {
+        expirationUnix = expiration.Unix()
+    }
+    payload := map[string]interface{}{
+        "profile": map[string]interface{}{
+            "status_text":       text,
+            "status_emoji":      emoji,
+            "status_expiration": expirationUnix,
+        },
+    }
+    err := slackAPIPost("users.profile.set", payload, config.SlackUserToken, nil)
+    if err != nil {
+        return err
+    }
+
+    if emoji == "" && text == "" {
+        fmt.Println("Status cleared")
+    } else if expirationUnix > 0 {
+        fmt.Printf("Status set to %s %s until %s\n", emoji, text, expiration.Format("2006-01-02 15:04"))
+    } else {
+        fmt.Printf("Status set to %s %s\n", emoji, text)
+    }
+    return nil
+}
+
+func clearStatus() error {
+    return setStatus("", "", time.Time{})
+}
+
+func showStatus() error {
+    var response struct {
+        Profile struct {
+            StatusText       string `json:"status_text"`
+            StatusEmoji      string `json:"status_emoji"`
+            StatusExpiration int64  `json:"status_expiration"`
+        } `json:"profile"`
+    }
+    err := slackAPIGet("users.profile.get", nil, config.SlackUserToken, &response)
+    if err != nil {
+        return err
+    }
+    profile := response.Profile
+    if profile.StatusText == "" && profile.StatusEmoji == "" {
+        fmt.Println("No status set")
+        return nil
+    }
+    status := strings.TrimSpace(profile.StatusEmoji + " " + profile.StatusText)
+    if profile.StatusExpiration > 0 {
+        status += " (until " + time.Unix(profile.StatusExpiration, 0).Format("2006-01-02 15:04") + ")"
+    }
+    fmt.Println("Current status:", status)
+    return nil
+}
+
+// CalendarEvent is a VEVENT. ExDates are the starts of occurrences of a
+// recurring event that were removed (EXDATE) or replaced by an override,
+// which is an event of its own with RecurrenceID set.
+type CalendarEvent struct {
+    UID          string
+    Summary      string
+    Start        time.Time
+    End          time.Time
+    AllDay       bool
+    RRule        map[string]string
+    ExDates      []time.Time
+    RecurrenceID time.Time
+}
+
+// parseICS reads the VEVENTs of an iCalendar file. Cancelled and
+// "transparent" (free) events are skipped. An override of one occurrence
+// (RECURRENCE-ID) removes that occurrence from the recurring event, even
+// when the override itself is cancelled.
+func parseICS(r io.Reader) ([]CalendarEvent, error) {
+    data, err := io.ReadAll(r)
+    if err != nil {
+        return nil, err
+    }
+
+    // Unfold continuation lines (RFC 5545 section 3.1).
+    text := strings.ReplaceAll(string(data), "\r\n", "\n")
+    text = strings.ReplaceAll(text, "\n ", "")
+    text = strings.ReplaceAll(text, "\n\t", "")
+
+    var events []CalendarEvent
+    var event *CalendarEvent
+    var duration time.Duration
+    skip := false
+    overridden := make(map[string][]time.Time)
+    for lineNumber, line := range strings.Split(text, "\n") {
+        line = strings.TrimRight(line, "\r")
+        colon := strings.Index(line, ":")
+        if colon < 0 {
+            continue
+        }
+        nameAndParams, value := line[:colon], line[colon+1:]
+        parts := strings.Split(nameAndParams, ";")
+        name := strings.ToUpper(parts[0])
+        params := make(map[string]string)
+        for _, param := range parts[1:] {
+            if eq := strings.Index(param, "="); eq > 0 {
+                params[strings.ToUpper(param[:eq])] = strings.Trim(param[eq+1:], "\"")
+            }
+        }
+
+        switch {
+        case name == "BEGIN" && value == "VEVENT":
+            event = &CalendarEvent{}
+            duration = 0
+            skip = false
+        case name == "END" && value == "VEVENT":
+            if event == nil {
+                return nil, fmt.Errorf("line %d: END:VEVENT without BEGIN", lineNumber+1)
+            }
+            if event.End.IsZero() {
+                if duration > 0 {
+                    event.End = event.Start.Add(duration)
+                } else if event.AllDay {
+                    event.End = event.Start.AddDate(0, 0, 1)
+                } else {
+                    event.End = event.Start
+                }
+            }
+            if !event.RecurrenceID.IsZero() {
+                overridden[event.UID] = append(overridden[event.UID], event.RecurrenceID)
+            }
+            if !skip && !event.Start.IsZero() {
+                events = append(events, *event)
+            }
+            event = nil
+        case event == nil:
+        case name == "UID":
+            event.UID = value
+        case name == "SUMMARY":
+            event.Summary = unescapeICS(value)
+        case name == "DTSTART":
+            event.Start, event.AllDay, err = parseICSTime(value, params)
+            if err != nil {
+                return nil, fmt.Errorf("line %d: %v", lineNumber+1, err)
+            }
+        case name == "DTEND":
+            event.End, _, err = parseICSTime(value, params)
+            if err != nil {
+                return nil, fmt.Errorf("line %d: %v", lineNumber+1, err)
+            }
+        case name == "EXDATE":
+            for _, exDate := range strings.Split(value, ",") {
+                excluded, _, err := parseICSTime(exDate, params)
+                if err != nil {
+                    return nil, fmt.Errorf("line %d: %v", lineNumber+1, err)
+                }
+                event.ExDates = append(event.ExDates, excluded)
+            }
+        case name == "RECURRENCE-ID":
+            event.RecurrenceID, _, err = parseICSTime(value, params)
+            if err != nil {
+                return nil, fmt.Errorf("line %d: %v", lineNumber+1, err)
+            }
+        case name == "DURATION":
+            duration, err = parseICSDuration(value)
+            if err != nil {
+                return nil, fmt.Errorf("line %d: %v", lineNumber+1, err)
+            }
+        case name == "RRULE":
+            event.RRule = make(map[string]string)
+            for _, rule := range strings.Split(value, ";") {
+                if eq := strings.Index(rule, "="); eq > 0 {
+                    event.RRule[strings.ToUpper(rule[:eq])] = rule[eq+1:]
+                }
+            }
+        case name == "STATUS" && strings.EqualFold(value, "CANCELLED"):
+            skip = true
+        case name == "TRANSP" && strings.EqualFold(value, "TRANSPARENT"):
+            skip = true
+        }
+    }
+
+    for i := range events {
+        if events[i].RRule != nil && events[i].RecurrenceID.IsZero() {
+            events[i].ExDates = append(events[i].ExDates, overridden[events[i].UID]...)
+        }
+    }
+    return events, nil
+}
+
+func unescapeICS(value string) string {
+    replacer := strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`)
+    return replacer.Replace(value)
+}
+
+// parseICSTime parses DATE and DATE-TIME values, honouring a TZID parameter
+// and the UTC "Z" suffix. Unknown time zones fall back to local time.
+func parseICSTime(value string, params map[string]string) (time.Time, bool, error) {
+    if params["VALUE"] == "DATE" || len(value) == 8 {
+        parsed, err := time.ParseInLocation("20060102", value, time.Local)
+        return parsed, true, err
+    }
+    if strings.HasSuffix(value, "Z") {
+        parsed, err := time.Parse("20060102T150405Z", value)
+        return parsed, false, err
+    }
+    location := time.Local
+    if tzid := params["TZID"]; tzid != "" {
+        if loaded, err := time.LoadLocation(tzid); err == nil {
+            location = loaded
+        }
+    }
+    parsed, err := time.ParseInLocation("20060102T150405", value, location)
+    return parsed, false, err
+}
+
+// parseICSDuration parses durations such as PT1H30M or P1D.
+func parseICSDuration(value string) (time.Duration, error) {
+    rest := strings.TrimPrefix(strings.TrimPrefix(value, "+"), "P")
+    if rest == value || rest == "" {
+        return 0, fmt.Errorf("invalid duration %q", value)
+    }
+    var duration time.Duration
+    inTime := false
+    number := ""
+    for _, r := range rest {
+        switch {
+        case r == 'T':
+            inTime = true
+        case r >= '0' && r <= '9':
+            number += string(r)
+        default:
+            n, err := strconv.Atoi(number)
+            if err != nil {
+                return 0, fmt.Errorf("invalid duration %q", value)
+            }
+            number = ""
+            switch {
+            case r == 'W':
+                duration += time.Duration(n) * 7 * 24 * time.Hour
+            case r == 'D':
+                duration += time.Duration(n) * 24 * time.Hour
+            case r == 'H' && inTime:
+                duration += time.Duration(n) * time.Hour
+            case r == 'M' && inTime:
+                duration += time.Duration(n) * time.Minute
+            case r == 'S' && inTime:
+                duration += time.Duration(n) * time.Second
+            default:
+                return 0, fmt.Errorf("invalid duration %q", value)
+            }
+        }
+    }
+    return duration, nil
+}
+
+// occurrenceAt returns the occurrence of event that is in progress at now.
+// Recurring events support FREQ=DAILY and FREQ=WEEKLY with INTERVAL, COUNT,
+// UNTIL, BYDAY and WKST. Weekly intervals count calendar weeks starting on
+// WKST (Monday by default), and occurrences in ExDates are skipped.
+func occurrenceAt(event CalendarEvent, now time.Time) (CalendarEvent, bool) {
+    length := event.End.Sub(event.Start)
+    inProgress := func(start time.Time) bool {
+        return !now.Before(start) && now.Before(start.Add(length))
+    }
+
+    if event.RRule == nil {
+        return event, inProgress(event.Start)
+    }
+
+    freq := event.RRule["FREQ"]
+    if freq != "DAILY" && freq != "WEEKLY" {
+        return event, inProgress(event.Start)
+    }
+    interval := 1
+    if value, err := strconv.Atoi(event.RRule["INTERVAL"]); err == nil && value > 0 {
+        interval = value
+    }
+    count := -1
+    if value, err := strconv.Atoi(event.RRule["COUNT"]); err == nil {
+        count = value
+    }
+    var until time.Time
+    if value := event.RRule["UNTIL"]; value != "" {
+        until, _, _ = parseICSTime(value, map[string]string{})
+    }
+    byDay := make(map[time.Weekday]bool)
+    weekdays := map[string]time.Weekday{"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday}
+    for _, day := range strings.Split(event.RRule["BYDAY"], ",") {
+        if weekday, exists := weekdays[day]; exists {
+            byDay[weekday] = true
+        }
+    }
+    if freq == "WEEKLY" && len(byDay) == 0 {
+        byDay[event.Start.Weekday()] = true
+    }
+    weekStart := time.Monday
+    if weekday, exists := weekdays[event.RRule["WKST"]]; exists {
+        weekStart = weekday
+    }
+    // Days from the start of DTSTART's week to DTSTART.
+    startOffset := (int(event.Start.Weekday()) - int(weekStart) + 7) % 7
+    excluded := func(start time.Time) bool {
+        for _, exDate := range event.ExDates {
+            if exDate.Equal(start) {
+                return true
+            }
+        }
+        return false
+    }
+
+    occurrences := 0
+    for day := 0; ; day++ {
+        start := event.Start.AddDate(0, 0, day)
+        if start.After(now) || (!until.IsZero() && start.After(until)) || (count >= 0 && occurrences >= count) {
+            return event, false
+        }
+
+        matches := false
+        if freq == "DAILY" {
+            matches = day%interval == 0
+        } else {
+            week := (day + startOffset) / 7
+            matches = week%interval == 0 && byDay[start.Weekday()]
+        }
+        if !matches {
+            continue
+        }
+        // Excluded occurrences still count towards COUNT.
+        occurrences++
+        if inProgress(start) && !excluded(start) {
+            occurrence := event
+            occurrence.Start = start
+            occurrence.End = start.Add(length)
+            return occurrence, true
+        }
+    }
+}
+
+// calendarRuleFor returns the first configured rule whose keyword appears in
+// the event summary, or the default rule.
+func calendarRuleFor(event CalendarEvent) CalendarRule {
+    summary := strings.ToLower(event.Summary)
+    for _, rule := range config.CalendarRules {
+        if rule.Keyword != "" && strings.Contains(summary, strings.ToLower(rule.Keyword)) {
+            return rule
+        }
+    }
+    for _, rule := range config.CalendarRules {
+        if rule.Keyword == "" {
+            return rule
+        }
+    }
+    return CalendarRule{Emoji: ":calendar:", DND: true}
+}
+
+// activeCalendarEvent picks the event in progress at now. Events matching a
+// keyword rule win over the rest, then timed events over all-day ones, then
+// the one ending first.
+func activeCalendarEvent(events []CalendarEvent, now time.Time) (CalendarEvent, bool) {
+    var active []CalendarEvent
+    for _, event := range events {
+        if occurrence, ok := occurrenceAt(event, now); ok {
+            active = append(active, occurrence)
+        }
+    }
+    if len(active) == 0 {
+        return CalendarEvent{}, false
+    }
+
+    hasKeyword := func(event CalendarEvent) bool {
+        return calendarRuleFor(event).Keyword != ""
+    }
+    sort.SliceStable(active, func(i, j int) bool {
+        if hasKeyword(active[i]) != hasKeyword(active[j]) {
+            return hasKeyword(active[i])
+        }
+        if active[i].AllDay != active[j].AllDay {
+            return !active[i].AllDay
+        }
+        return active[i].End.Before(active[j].End)
+    })
+    return active[0], true
+}
+
+// calendarStatusFor returns the status the matching rule sets for an event
+// occurrence. All-day events never turn on Do Not Disturb.
+func calendarStatusFor(event CalendarEvent) *CalendarStatusState {
+    rule := calendarRuleFor(event)
+    calendarStatus := &CalendarStatusState{
+        EventKey: event.UID + "@" + strconv.FormatInt(event.Start.Unix(), 10),
+        Summary:  event.Summary,
+        Text:     rule.Text,
+        End:      event.End.Unix(),
+        DND:      rule.DND && !event.AllDay,
+    }
+    if rule.Emoji != "" {
+        calendarStatus.Emoji = ":" + strings.Trim(rule.Emoji, ":") + ":"
+    }
+    if calendarStatus.Text == "" {
+        calendarStatus.Text = event.Summary
+    }
+    return calendarStatus
+}
+
+// syncCalendarStatus sets the status (and DND for meetings) from the event in
+// progress, or clears what a previous run set once the event has ended. It
+// makes a single pass so it can be run from cron.
+func syncCalendarStatus(icsFile string, now time.Time, dryRun bool) error {
+    file, err := os.Open(icsFile)
+    if err != nil {
+        return fmt.Errorf("could not open %s: %v", icsFile, err)
+    }
+    defer file.Close()
+
+    events, err := parseICS(file)
+    if err != nil {
+        return fmt.Errorf("could not parse %s: %v", icsFile, err)
+    }
+
+    err = loadState()
+    if err != nil {
+        return err
+    }
+    previous := state.CalendarStatus
+
+    event, ok := activeCalendarEvent(events, now)
+    if !ok {
+        if previous == nil {
+            fmt.Println("No event in progress")
+            return nil
+        }
+        fmt.Printf("Event %q has ended, clearing status\n", previous.Summary)
+        if dryRun {
+            return nil
+        }
+        err = clearCalendarStatus(*previous)
+        if err != nil {
+            return err
+        }
+        return updateState(func() {
+            state.CalendarStatus = nil
+        })
+    }
+
+    calendarStatus := calendarStatusFor(event)
+    if previous != nil && previous.EventKey == calendarStatus.EventKey {
+        fmt.Printf("Status for %q is already set\n", event.Summary)
+        return nil
+    }
+
+    minutes := int(event.End.Sub(now).Minutes() + 0.5)
+    calendarStatus.DND = calendarStatus.DND && minutes > 0
+    endSnooze := previous != nil && previous.DND && !calendarStatus.DND
+    fmt.Printf("Event in progress: %q until %s\n", event.Summary, event.End.Format("2006-01-02 15:04"))
+    if dryRun {
+        fmt.Printf("Would set status %s %s until %s\n", calendarStatus.Emoji, calendarStatus.Text, event.End.Format("2006-01-02 15:04"))
+        if calendarStatus.DND {
+            fmt.Printf("Would snooze notifications for %d minutes\n", minutes)
+        } else if endSnooze {
+            fmt.Printf("Would end the snooze set for %q\n", previous.Summary)
+        }
+        return nil
+    }
+
+    err = setStatus(calendarStatus.Emoji, calendarStatus.Text, event.End)
+    if err != nil {
+        return err
+    }
+    if calendarStatus.DND {
+        err = setSnooze(minutes)
+        if err != nil {
+            return err
+        }
+    } else if endSnooze {
+        err = endCalendarSnooze()
+        if err != nil {
+            return err
+        }
+    }
+
+    return updateState(func() {
+        state.CalendarStatus = calendarStatus
+    })
+}
+
+// clearCalendarStatus clears the status and DND set by a previous sync, but
+// leaves a status the user has changed since then alone.
+func clearCalendarStatus(previous CalendarStatusState) error {
+    var response struct {
+        Profile struct {
+            StatusText  string `json:"status_text"`
+            StatusEmoji string `json:"status_emoji"`
+        } `json:"profile"`
+    }
+    err := slackAPIGet("users.profile.get", nil, config.SlackUserToken, &response)
+    if err != nil {
+        return err
+    }
+    if response.Profile.StatusText == previous.Text && response.Profile.StatusEmoji == previous.Emoji {
+        err = clearStatus()
+        if err != nil {
+            return err
+        }
+    } else {
+        fmt.Println("Status was changed manually, leaving it")
+    }
+
+    if previous.DND {
+        return endCalendarSnooze()
+    }
+    return nil
+}
+
+// endCalendarSnooze ends the snooze a previous sync set. Unlike endDND it
+// never ends a scheduled DND window, which the sync did not turn on.
+func endCalendarSnooze() error {
+    err := slackAPIPost("dnd.endSnooze", map[string]string{}, config.SlackUserToken, nil)
+    if err != nil && !strings.Contains(err.Error(), "snooze_not_active") {
+        return err
+    }
+    if err == nil {
+        fmt.Println("Notifications snooze ended")
+    }
+    return nil
+}
+
+// setSnooze turns on Do Not Disturb for the given number of minutes.
+func setSnooze(minutes int) error {
+    payload := map[string]interface{}{
+        "num_minutes": minutes,
+    }
+    err := slackAPIPost("dnd.setSnooze", payload, config.SlackUserToken, nil)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Notifications snoozed for %d minutes\n", minutes)
+    return nil
+}
+
+type DNDInfo struct {
+    DNDEnabled      bool  `json:"dnd_enabled"`
+    NextDNDStartTs  int64 `json:"next_dnd_start_ts"`
+    NextDNDEndTs    int64 `json:"next_dnd_end_ts"`
+    SnoozeEnabled   bool  `json:"snooze_enabled"`
+    SnoozeEndtime   int64 `json:"snooze_endtime"`
+    SnoozeRemaining int64 `json:"snooze_remaining"`
+}
+
+func getDNDInfo(userID string) (DNDInfo, error) {
+    var info DNDInfo
+    params := url.Values{}
+    if userID != "" {
+        params.Set("user", userID)
+    }
+    err := slackAPIGet("dnd.info", params, config.SlackUserToken, &info)
+    return info, err
+}
+
+// formatRemaining formats a duration as "1h05m" or "12m".
+func formatRemaining(duration time.Duration) string {
+    duration = duration.Round(time.Minute)
+    hours := int(duration.Hours())
+    minutes := int(duration.Minutes()) % 60
+    if hours > 0 {
+        return fmt.Sprintf("%dh%02dm", hours, minutes)
+    }
+    return fmt.Sprintf("%dm", minutes)
+}
+
+// showDNDStatus prints the snooze and scheduled DND state of the given user,
+// or of the token owner when target is empty.
+func showDNDStatus(target string) error {
+    userID := ""
+    name := "you"
+    if target != "" {
+        var err error
+        userID, err = findUserID(strings.TrimPrefix(target, "@"))
+        if err != nil {
+            return err
+        }
+        name = getUserName(userID, make(map[string]string))
+    }
+
+    info, err := getDNDInfo(userID)
+    if err != nil {
+        return err
+    }
+
+    now := time.Now()
+    fmt.Printf("Do Not Disturb for %s:\n", name)
+    if info.SnoozeEnabled && info.SnoozeEndtime > now.Unix() {
+        end := time.Unix(info.SnoozeEndtime, 0)
+        fmt.Printf("  Snoozed: yes, %s remaining (until %s)\n", formatRemaining(end.Sub(now)), end.Format("2006-01-02 15:04"))
+    } else {
+        fmt.Println("  Snoozed: no")
+    }
+
+    if info.DNDEnabled && info.NextDNDStartTs > 0 {
+        start := time.Unix(info.NextDNDStartTs, 0)
+        end := time.Unix(info.NextDNDEndTs, 0)
+        fmt.Printf("  Schedule: %s - %s\n", start.Format("15:04"), end.Format("15:04"))
+        if !now.Before(start) && now.Before(end) {
+            fmt.Printf("  In scheduled DND now, %s remaining\n", formatRemaining(end.Sub(now)))
+        } else {
+            fmt.Printf("  Next window: %s - %s\n", start.Format("2006-01-02 15:04"), end.Format("2006-01-02 15:04"))
+        }
+    } else {
+        fmt.Println("  Schedule: none")
+    }
+    return nil
+}
+
+// endDND ends a snooze, or the current scheduled DND window if nothing is
+// snoozed.
+func endDND() error {
+    info, err := getDNDInfo("")
+    if err != nil {
+        return err
+    }
+    if info.SnoozeEnabled {
+        err = slackAPIPost("dnd.endSnooze", map[string]string{}, config.SlackUserToken, nil)
+    } else {
+        err = slackAPIPost("dnd.endDnd", map[string]string{}, config.SlackUserToken, nil)
+    }
+    if err != nil {
+        return err
+    }
+    fmt.Println("Do Not Disturb turned off")
+    return nil
+}
+
+// editorScissors separates the message from the help text in the editor
+// template; everything from this line on is discarded.
+const editorScissors = "# ------------------------ >8 ------------------------"
+
+// readMessageText returns the message to send: the argument itself, stdin
+// for "-", the contents of --file, or, when neither is given, text composed
+// in $EDITOR. The editor is only used on a terminal; otherwise the message
+// is read from stdin.
+func readMessageText(args []string, fileName, threadTS string) (string, error) {
+    if fileName != "" {
+        data, err := os.ReadFile(fileName)
+        if err != nil {
+            return "", fmt.Errorf("could not read message file: %v", err)
+        }
+        return strings.TrimRight(string(data), "\r\n"), nil
+    }
+    if len(args) > 0 && args[0] == "-" {
+        data, err := io.ReadAll(os.Stdin)
+        if err != nil {
+            return "", fmt.Errorf("could not read message from stdin: %v", err)
+        }
+        return strings.TrimRight(string(data), "\r\n"), nil
+    }
+    if len(args) > 0 {
+        return args[0], nil
+    }
+    if !stdinIsTerminal() {
+        data, err := io.ReadAll(os.Stdin)
+        if err != nil {
+            return "", fmt.Errorf("could not read message from stdin: %v", err)
+        }
+        message := strings.TrimRight(string(data), "\r\n")
+        if strings.TrimSpace(message) == "" {
+            return "", fmt.Errorf("message is required, stdin is not a terminal and no message was piped")
+        }
+        return message, nil
+    }
+    return composeInEditor(threadTS)
+}
+
+// stdinIsTerminal reports whether stdin is an interactive terminal. The
+// null device is a character device too, so it is ruled out explicitly.
+func stdinIsTerminal() bool {
+    stat, err := os.Stdin.Stat()
+    if err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+        return false
+    }
+    null, err := os.Stat(os.DevNull)
+    return err != nil || !os.SameFile(stat, null)
+}
+
+// composeInEditor opens $VISUAL or $EDITOR on a temporary file with a short
+// template naming the target channel and thread.
+func composeInEditor(threadTS string) (string, error) {
+    editor := os.Getenv("VISUAL")
+    if editor == "" {
+        editor = os.Getenv("EDITOR")
+    }
+    if editor == "" {
+        editor = "vi"
+        if runtime.GOOS == "windows" {
+            editor = "notepad"
+        }
+    }
+
+    tempFile, err := os.CreateTemp("", "slack-message-*.txt")
+    if err != nil {
+        return "", fmt.Errorf("could not create temporary file: %v", err)
+    }
+    defer os.Remove(tempFile.Name())
+
+    template := "\n\n" + editorScissors + "\n"
+    template += "# Write your message above this line. Everything below it is ignored.\n"
+    template += "# Save an empty message to abort.\n"
+    template += fmt.Sprintf("# Channel: %s (%s)\n", channelDisplayName(currentChannelID()), currentChannelID())
+    if threadTS != "" {
+        template += fmt.Sprintf("# Thread: %s\n", threadTS)
+    }
+    _, err = tempFile.WriteString(template)
+    tempFile.Close()
+    if err != nil {
+        return "", fmt.Errorf("could not write temporary file: %v", err)
+    }
+
+    editorArgs := strings.Fields(editor)
+    cmd := exec.Command(editorArgs[0], append(editorArgs[1:], tempFile.Name())...)
+    cmd.Stdin = os.Stdin
+    cmd.Stdout = os.Stdout
+    cmd.Stderr = os.Stderr
+    err = cmd.Run()
+    if err != nil {
+        return "", fmt.Errorf("editor %s failed: %v", editor, err)
+    }
+
+    data, err := os.ReadFile(tempFile.Name())
+    if err != nil {
+        return "", fmt.Errorf("could not read temporary file: %v", err)
+    }
+    message := strings.ReplaceAll(string(data), "\r\n", "\n")
+    if index := strings.Index(message, editorScissors); index >= 0 {
+        message = message[:index]
+    }
+    message = strings.TrimSpace(message)
+    if message == "" {
+        return "", fmt.Errorf("aborting due to empty message")
+    }
+    return message, nil
+}
+
+// postMessage posts text to a channel and returns the message timestamp.
+func postMessage(channelID, text, threadTS string) (string, error) {
+    payload := map[string]string{
+        "channel": channelID,
+        "text":    text,
+    }
+    if threadTS != "" {
+        payload["thread_ts"] = threadTS
+    }
+
+    var response struct {
+        Ts string `json:"ts"`
+    }
+    err := slackAPIPost("chat.postMessage", payload, config.SlackUserToken, &response)
+    return response.Ts, err
+}
+
+// uploadContent uploads in-memory content as a file to a channel, optionally
+// into a thread, using the files.getUploadURLExternal flow.
+func uploadContent(channelID, fileName string, content []byte, title, threadTS string) error {
+    var uploadURLResponse GetUploadURLResponse
+    params := url.Values{}
+    params.Set("filename", fileName)
+    params.Set("length", strconv.Itoa(len(content)))
+    err := slackAPIGet("files.getUploadURLExternal", params, config.SlackBotToken, &uploadURLResponse)
+    if err != nil {
+        return err
+    }
+
+    req, _ := http.NewRequest("POST", uploadURLResponse.UploadURL, bytes.NewReader(content))
+    req.Header.Set("Content-Type", "application/octet-stream")
+    client := &http.Client{}
+    resp, err := client.Do(req)
+    if err != nil {
+        return fmt.Errorf("error uploading file: %v", err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("error uploading file: %s", resp.Status)
+    }
+
+    payload := map[string]interface{}{
+        "files": []map[string]string{
+            {
+                "id":    uploadURLResponse.FileID,
+                "title": title,
+            },
+        },
+        "channel_id": channelID,
+    }
+    if threadTS != "" {
+        payload["thread_ts"] = threadTS
+    }
+    return slackAPIPost("files.completeUploadExternal", payload, config.SlackBotToken, nil)
+}
+
+// escapeSlackText escapes the characters Slack treats as markup in message
+// text.
+func escapeSlackText(text string) string {
+    replacer := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+    return replacer.Replace(text)
+}
+
+// codeBlock wraps text in a Slack code block, breaking up any ``` inside it.
+func codeBlock(text string) string {
+    text = strings.ReplaceAll(text, "```", "`\u200b``")
+    return "```\n" + escapeSlackText(text) + "\n```"
+}
+
+// tailOutput keeps the last maxLines lines and at most maxChars characters of
+// output, and reports whether anything was cut.
+func tailOutput(output string, maxLines, maxChars int) (string, bool) {
+    output = strings.TrimRight(output, "\n")
+    lines := strings.Split(output, "\n")
+    truncated := false
+    if maxLines > 0 && len(lines) > maxLines {
+        omitted := len(lines) - maxLines
+        lines = append([]string{fmt.Sprintf("... %d earlier lines omitted ...", omitted)}, lines[omitted:]...)
+        truncated = true
+    }
+    output = strings.Join(lines, "\n")
+    if maxChars > 0 && len(output) > maxChars {
+        cut := len(output) - maxChars
+        for cut < len(output) && !utf8.RuneStart(output[cut]) {
+            cut++
+        }
+        output = "... " + output[cut:]
+        if index := strings.Index(output, "\n"); index >= 0 {
+            output = "..." + output[index:]
+        }
+        truncated = true
+    }
+    return output, truncated
+}
+
+func formatElapsed(duration time.Duration) string {
+    if duration < time.Second {
+        return duration.Round(time.Millisecond).String()
+    }
+    return duration.Round(time.Second).String()
+}
+
+// lockedBuffer collects stdout and stderr of a child process, which are
+// written from separate goroutines.
+type lockedBuffer struct {
+    mu     sync.Mutex
+    buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buffer.String()
+}
+
+// commandExitCode returns the exit code of a finished command; 127 means it
+// could not be started.
+func commandExitCode(err error) int {
+    if err == nil {
+        return 0
+    }
+    if exitErr, ok := err.(*exec.ExitError); ok {
+        return exitErr.ExitCode()
+    }
+    return 127
+}
+
+// formatCommandLine quotes arguments containing spaces for display.
+func formatCommandLine(args []string) string {
+    quoted := make([]string, len(args))
+    for i, arg := range args {
+        if arg == "" || strings.ContainsAny(arg, " \t\"'") {
+            quoted[i] = strconv.Quote(arg)
+        } else {
+            quoted[i] = arg
+        }
+    }
+    return strings.Join(quoted, " ")
+}
+
+// execAndReport runs a command, echoing its output, then posts the command
+// line, exit code, duration and output. When the output is too long for the
+// message, the full log is attached to the thread if upload is set. With
+// live set, the message is posted right away and updated while the command
+// runs, and the full log is always attached. It returns the command's exit
+// code.
+func execAndReport(args []string, threadTS string, maxLines int, upload, live bool, interval time.Duration) int {
+    var output lockedBuffer
+    cmd := exec.Command(args[0], args[1:]...)
+    cmd.Stdin = os.Stdin
+    cmd.Stdout = io.MultiWriter(os.Stdout, &output)
+    cmd.Stderr = io.MultiWriter(os.Stderr, &output)
+
+    // Ctrl-C reaches the child directly; keep running so the result is posted.
+    signal.Ignore(os.Interrupt)
+
+    commandLine := formatCommandLine(args)
+    var updater *liveUpdater
+    if live {
+        var err error
+        updater, err = startLiveUpdater(currentChannelID(), threadTS, fmt.Sprintf("`$ %s`", escapeSlackText(commandLine)), &output, maxLines, interval)
+        if err != nil {
+            fmt.Println("Error sending message:", err)
+        }
+    }
+
+    start := time.Now()
+    err := cmd.Run()
+    elapsed := time.Since(start)
+    exitCode := commandExitCode(err)
+    if exitCode == 127 && err != nil {
+        fmt.Fprintln(&output, err)
+        fmt.Println("Error running command:", err)
+    }
+
+    icon := ":white_check_mark:"
+    if exitCode != 0 {
+        icon = ":x:"
+    }
+    message := fmt.Sprintf("%s `$ %s` exited with %d after %s", icon, escapeSlackText(commandLine), exitCode, formatElapsed(elapsed))
+
+    fullOutput := output.String()
+    shown, truncated := tailOutput(fullOutput, maxLines, 3500)
+    if strings.TrimSpace(shown) != "" {
+        message += "\n" + codeBlock(shown)
+    } else {
+        message += "\n_(no output)_"
+    }
+    if updater != nil {
+        if strings.TrimSpace(fullOutput) != "" {
+            message += "\n_Full output attached in the thread._"
+        }
+        err = updater.finish(message, "output.log", commandLine)
+        if err != nil {
+            fmt.Println("Error updating message:", err)
+        }
+        return exitCode
+    }
+    if truncated && upload {
+        message += "\n_Full output attached in the thread._"
+    }
+
+    ts, err := postMessage(currentChannelID(), message, threadTS)
+    if err != nil {
+        fmt.Println("Error sending message:", err)
+        return exitCode
+    }
+    fmt.Println("Message sent successfully")
+
+    if truncated && upload {
+        parentTS := threadTS
+        if parentTS == "" {
+            parentTS = ts
+        }
+        err = uploadContent(currentChannelID(), "output.log", []byte(fullOutput), commandLine, parentTS)
+        if err != nil {
+            fmt.Println("Error uploading output:", err)
+        }
+    }
+    return exitCode
+}
+
+// editMessage replaces the text of a message. Messages can only be edited
+// with the token that posted them: postMessage uses the user token.
+func editMessage(channelID, ts, text, token string) error {
+    payload := map[string]string{
+        "channel": channelID,
+        "ts":      ts,
+        "text":    text,
+    }
+    return slackAPIPost("chat.update", payload, token, nil)
+}
+
+// liveUpdater keeps a single Slack message in sync with growing output,
+// updating it at most once per interval and backing off when rate limited.
+type liveUpdater struct {
+    channelID string
+    threadTS  string
+    ts        string
+    header    string
+    output    *lockedBuffer
+    maxLines  int
+    interval  time.Duration
+    started   time.Time
+    lastText  string
+    stop      chan struct{}
+    done      chan struct{}
+}
+
+// startLiveUpdater posts the initial message and starts updating it in the
+// background.
+func startLiveUpdater(channelID, threadTS, header string, output *lockedBuffer, maxLines int, interval time.Duration) (*liveUpdater, error) {
+    if interval < time.Second {
+        interval = time.Second
+    }
+    updater := &liveUpdater{
+        channelID: channelID,
+        threadTS:  threadTS,
+        header:    header,
+        output:    output,
+        maxLines:  maxLines,
+        interval:  interval,
+        started:   time.Now(),
+        stop:      make(chan struct{}),
+        done:      make(chan struct{}),
+    }
+    updater.lastText = updater.render()
+    ts, err := postMessage(channelID, updater.lastText, threadTS)
+    if err != nil {
+        return nil, err
+    }
+    updater.ts = ts
+    go updater.run()
+    return updater, nil
+}
+
+func (u *liveUpdater) render() string {
+    text := fmt.Sprintf(":hourglass_flowing_sand: %s (running for %s)", u.header, formatElapsed(time.Since(u.started)))
+    shown, _ := tailOutput(u.output.String(), u.maxLines, 3500)
+    if strings.TrimSpace(shown) != "" {
+        text += "\n" + codeBlock(shown)
+    }
+    return text
+}
+
+func (u *liveUpdater) run() {
+    defer close(u.done)
+    delay := u.interval
+    for {
+        select {
+        case <-u.stop:
+            return
+        case <-time.After(delay):
+        }
+
+        text := u.render()
+        if text == u.lastText {
+            continue
+        }
+        err := editMessage(u.channelID, u.ts, text, config.SlackUserToken)
+        if err != nil {
+            if strings.Contains(err.Error(), "ratelimited") && delay < time.Minute {
+                delay *= 2
+            }
+            continue
+        }
+        u.lastText = text
+        delay = u.interval
+    }
+}
+
+// finish stops the periodic updates, replaces the message with the final
+// text and attaches the full log to the thread.
+func (u *liveUpdater) finish(finalText, fileName, title string) error {
+    close(u.stop)
+    <-u.done
+
+    err := editMessage(u.channelID, u.ts, finalText, config.SlackUserToken)
+    if err != nil {
+        return err
+    }
+
+    if strings.TrimSpace(u.output.String()) == "" {
+        return nil
+    }
+    parentTS := u.threadTS
+    if parentTS == "" {
+        parentTS = u.ts
+    }
+    return uploadContent(u.channelID, fileName, []byte(u.output.String()), title, parentTS)
+}
+
+// streamStdin echoes stdin while keeping a live message updated with its
+// tail, then posts a final status line with the full log attached.
+func streamStdin(title, threadTS string, maxLines int, interval time.Duration) error {
+    if title == "" {
+        title = "Streaming output"
+    }
+    var output lockedBuffer
+    updater, err := startLiveUpdater(currentChannelID(), threadTS, escapeSlackText(title), &output, maxLines, interval)
+    if err != nil {
+        return err
+    }
+
+    _, readErr := io.Copy(io.MultiWriter(os.Stdout, &output), os.Stdin)
+
+    icon := ":white_check_mark:"
+    status := "finished"
+    if readErr != nil {
+        icon = ":warning:"
+        status = fmt.Sprintf("stopped (%v)", readErr)
+    }
+    finalText := fmt.Sprintf("%s %s %s after %s", icon, escapeSlackText(title), status, formatElapsed(time.Since(updater.started)))
+    shown, _ := tailOutput(output.String(), maxLines, 3500)
+    if strings.TrimSpace(shown) != "" {
+        finalText += "\n" + codeBlock(shown) + "\n_Full output attached in the thread._"
+    }
+    err = updater.finish(finalText, "output.log", title)
+    if err != nil {
+        return err
+    }
+    fmt.Println("Stream finished")
+    return nil
+}
+
+// renderJobMessage builds the status message of a job: a header with the
+// state emoji and title, followed by one checklist line per step.
+func renderJobMessage(job JobState, headerEmoji, suffix string) string {
+    started := time.Unix(job.Started, 0).Format("2006-01-02 15:04")
+    lines := []string{fmt.Sprintf("%s *%s* (started %s)%s", headerEmoji, escapeSlackText(job.Title), started, suffix)}
+    for _, step := range job.Steps {
+        icon := ":white_check_mark:"
+        if step.Failed {
+            icon = ":x:"
+        }
+        lines = append(lines, fmt.Sprintf("%s %s _(%s)_", icon, escapeSlackText(step.Text), time.Unix(step.Time, 0).Format("15:04:05")))
+    }
+    return strings.Join(lines, "\n")
+}
+
+func runningJob(name string) (*JobState, error) {
+    job, exists := state.Jobs[name]
+    if !exists {
+        return nil, fmt.Errorf("job %q is not running; start it with \"job start\"", name)
+    }
+    return job, nil
+}
+
+// startJob posts the status message of a new job and remembers its ts under
+// the job name. The name is reserved before posting, so steps added in the
+// meantime are kept and shown once the message exists.
+func startJob(name, title string) error {
+    job := JobState{
+        ChannelID: currentChannelID(),
+        Title:     title,
+        Started:   time.Now().Unix(),
+    }
+    err := withStateLock(func() error {
+        if _, exists := state.Jobs[name]; exists {
+            return fmt.Errorf("job %q is already running; finish it first", name)
+        }
+        if state.Jobs == nil {
+            state.Jobs = make(map[string]*JobState)
+        }
+        reserved := job
+        state.Jobs[name] = &reserved
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    ts, postErr := postMessage(job.ChannelID, renderJobMessage(job, ":hourglass_flowing_sand:", ""), "")
+    err = updateState(func() {
+        if postErr != nil {
+            delete(state.Jobs, name)
+        } else if stored, exists := state.Jobs[name]; exists {
+            stored.Ts = ts
+        }
+    })
+    if postErr != nil {
+        return postErr
+    }
+    if err != nil {
+        return err
+    }
+    err = syncJobMessage(name, 0)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Job %q started (ts %s)\n", name, ts)
+    return nil
+}
+
+// syncJobMessage re-renders the job's message until it shows every step.
+// Concurrent "job step" calls can finish their edits out of order, so the
+// step count is checked again after each edit.
+func syncJobMessage(name string, shown int) error {
+    for {
+        err := loadState()
+        if err != nil {
+            return err
+        }
+        job, exists := state.Jobs[name]
+        if !exists || job.Ts == "" || len(job.Steps) <= shown {
+            return nil
+        }
+        snapshot := *job
+        err = editMessage(snapshot.ChannelID, snapshot.Ts, renderJobMessage(snapshot, ":hourglass_flowing_sand:", ""), config.SlackUserToken)
+        if err != nil {
+            return err
+        }
+        shown = len(snapshot.Steps)
+    }
+}
+
+// addJobStep appends a checklist line to the job's status message.
+func addJobStep(name, text string, failed bool) error {
+    var job JobState
+    err := withStateLock(func() error {
+        stored, err := runningJob(name)
+        if err != nil {
+            return err
+        }
+        stored.Steps = append(stored.Steps, JobStep{Text: text, Failed: failed, Time: time.Now().Unix()})
+        job = *stored
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    if job.Ts != "" {
+        err = editMessage(job.ChannelID, job.Ts, renderJobMessage(job, ":hourglass_flowing_sand:", ""), config.SlackUserToken)
+        if err != nil {
+            return err
+        }
+        err = syncJobMessage(name, len(job.Steps))
+        if err != nil {
+            return err
+        }
+    }
+    fmt.Printf("Step added to job %q\n", name)
+    return nil
+}
+
+// finishJob sets the final header emoji, adds a matching reaction and forgets
+// the job. The job is only forgotten once the message is updated, so a
+// failed finish can be retried.
+func finishJob(name string, ok bool) error {
+    var job JobState
+    err := withStateLock(func() error {
+        stored, err := runningJob(name)
+        if err != nil {
+            return err
+        }
+        if stored.Ts == "" {
+            return fmt.Errorf("job %q is still starting", name)
+        }
+        job = *stored
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    emoji, reaction, result := ":white_check_mark:", "white_check_mark", "succeeded"
+    if !ok {
+        emoji, reaction, result = ":x:", "x", "failed"
+    }
+    elapsed := time.Since(time.Unix(job.Started, 0))
+    suffix := fmt.Sprintf(" %s after %s", result, formatElapsed(elapsed))
+    err = editMessage(job.ChannelID, job.Ts, renderJobMessage(job, emoji, suffix), config.SlackUserToken)
+    if err != nil {
+        return err
+    }
+    err = updateState(func() {
+        if stored, exists := state.Jobs[name]; exists && stored.Ts == job.Ts {
+            delete(state.Jobs, name)
+        }
+    })
+    if err != nil {
+        return err
+    }
+
+    payload := map[string]string{
+        "channel":   job.ChannelID,
+        "name":      reaction,
+        "timestamp": job.Ts,
+    }
+    err = slackAPIPost("reactions.add", payload, config.SlackUserToken, nil)
+    if err != nil && !strings.Contains(err.Error(), "already_reacted") {
+        return err
+    }
+
+    fmt.Printf("Job %q %s\n", name, result)
+    return nil
+}
+
+func listJobs() error {
+    err := loadState()
+    if err != nil {
+        return err
+    }
+    if len(state.Jobs) == 0 {
+        fmt.Println("No running jobs")
+        return nil
+    }
+    var names []string
+    for name := range state.Jobs {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        job := state.Jobs[name]
+        fmt.Printf("%s: %s in %s (ts %s, %d steps, running for %s)\n", name, job.Title, channelDisplayName(job.ChannelID), job.Ts, len(job.Steps), formatElapsed(time.Since(time.Unix(job.Started, 0))))
+    }
+    return nil
+}
+
+// updateState re-reads the state file, applies change and saves it, so
+// long-running commands don't overwrite what other invocations stored in
+// the meantime.
+func updateState(change func()) error {
+    return withStateLock(func() error {
+        change()
+        return nil
+    })
+}
+
+// withStateLock runs fn with the state file locked against other
+// invocations. The state is loaded before fn and saved after it, even when
+// fn fails, so partial progress is kept. fn must not do network I/O, so the
+// lock is only ever held briefly.
+func withStateLock(fn func() error) error {
+    lockFileName := stateFileName + ".lock"
+    deadline := time.Now().Add(30 * time.Second)
+    for {
+        lockFile, err := os.OpenFile(lockFileName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+        if err == nil {
+            fmt.Fprintf(lockFile, "%d\n", os.Getpid())
+            lockFile.Close()
+            break
+        }
+        if !os.IsExist(err) {
+            return fmt.Errorf("could not lock state file: %v", err)
+        }
+        if staleStateLock(lockFileName) {
+            os.Remove(lockFileName)
+            continue
+        }
+        if time.Now().After(deadline) {
+            return fmt.Errorf("timed out waiting for %s", lockFileName)
+        }
+        time.Sleep(50 * time.Millisecond)
+    }
+    defer os.Remove(lockFileName)
+
+    err := loadState()
+    if err != nil {
+        return err
+    }
+    fnErr := fn()
+    err = saveState()
+    if fnErr != nil {
+        return fnErr
+    }
+    return err
+}
+
+// staleStateLock reports whether a lock was left behind by a process that is
+// no longer running. A lock without a readable PID is only considered stale
+// after a few seconds, since its holder may not have written the PID yet.
+func staleStateLock(lockFileName string) bool {
+    data, err := os.ReadFile(lockFileName)
+    if err != nil {
+        return false
+    }
+    pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+    if err != nil {
+        info, statErr := os.Stat(lockFileName)
+        return statErr == nil && time.Since(info.ModTime()) > 5*time.Second
+    }
+    return !processRunning(pid)
+}
+
+func processRunning(pid int) bool {
+    process, err := os.FindProcess(pid)
+    if err != nil {
+        return false
+    }
+    if runtime.GOOS == "windows" {
+        // FindProcess fails on Windows when the process is gone.
+        return true
+    }
+    err = process.Signal(syscall.Signal(0))
+    return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// logTimestampPattern matches a leading timestamp, which is ignored when
+// deciding whether two log lines are repeats.
+var logTimestampPattern = regexp.MustCompile(`^\[?\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}[\d.,]*(Z|[+-]\d{2}:?\d{2})?\]?\s*`)
+
+// lineBatch collects matched lines, counting repeats.
+type lineBatch struct {
+    started time.Time
+    order   []string
+    lines   map[string]string
+    counts  map[string]int
+}
+
+func (b *lineBatch) add(line string) {
+    if b.counts == nil {
+        b.lines = make(map[string]string)
+        b.counts = make(map[string]int)
+        b.started = time.Now()
+    }
+    key := logTimestampPattern.ReplaceAllString(line, "")
+    if _, exists := b.counts[key]; !exists {
+        b.order = append(b.order, key)
+        b.lines[key] = line
+    }
+    b.counts[key]++
+}
+
+func (b *lineBatch) empty() bool {
+    return len(b.order) == 0
+}
+
+func (b *lineBatch) render(fileName, pattern string) string {
+    total := 0
+    for _, count := range b.counts {
+        total += count
+    }
+    noun := "lines"
+    if total == 1 {
+        noun = "line"
+    }
+    header := fmt.Sprintf(":rotating_light: %d %s in `%s`", total, noun, escapeSlackText(fileName))
+    if pattern != "" {
+        header = fmt.Sprintf(":rotating_light: %d %s matching `%s` in `%s`", total, noun, escapeSlackText(pattern), escapeSlackText(fileName))
+    }
+
+    const maxLines = 50
+    var lines []string
+    for i, key := range b.order {
+        if i == maxLines {
+            lines = append(lines, fmt.Sprintf("... and %d more distinct lines", len(b.order)-maxLines))
+            break
+        }
+        line := b.lines[key]
+        if count := b.counts[key]; count > 1 {
+            line = fmt.Sprintf("%s (x%d)", line, count)
+        }
+        lines = append(lines, line)
+    }
+    shown, _ := tailOutput(strings.Join(lines, "\n"), 0, 3500)
+    return header + "\n" + codeBlock(shown)
+}
+
+// watchFile follows a log file like tail -F, posting lines that match
+// pattern in batches collected over window. The read offset is saved after
+// every successful post so a restart continues where it stopped. With
+// thread set, batches are posted as replies to one parent message.
+func watchFile(filePath, pattern string, window time.Duration, thread, fromStart bool) error {
+    absPath, err := filepath.Abs(filePath)
+    if err != nil {
+        return err
+    }
+    var match *regexp.Regexp
+    if pattern != "" {
+        match, err = regexp.Compile(pattern)
+        if err != nil {
+            return fmt.Errorf("invalid --match pattern: %v", err)
+        }
+    }
+
+    err = loadState()
+    if err != nil {
+        return err
+    }
+    watch := FileWatchState{Offset: -1}
+    if saved, exists := state.FileWatches[absPath]; exists {
+        watch = *saved
+    }
+    if watch.Offset < 0 && fromStart {
+        watch.Offset = 0
+    }
+
+    saveWatch := func() error {
+        return updateState(func() {
+            if state.FileWatches == nil {
+                state.FileWatches = make(map[string]*FileWatchState)
+            }
+            saved := watch
+            state.FileWatches[absPath] = &saved
+        })
+    }
+
+    var batch lineBatch
+    channelID := currentChannelID()
+    flush := func() error {
+        if batch.empty() {
+            return nil
+        }
+        message := batch.render(filepath.Base(absPath), pattern)
+        if thread && watch.ThreadTS == "" {
+            ts, err := postMessage(channelID, fmt.Sprintf(":eyes: Watching `%s`", escapeSlackText(absPath)), "")
+            if err != nil {
+                return err
+            }
+            watch.ThreadTS = ts
+        }
+        threadTS := ""
+        if thread {
+            threadTS = watch.ThreadTS
+        }
+        _, err := postMessage(channelID, message, threadTS)
+        if err != nil {
+            return err
+        }
+        batch = lineBatch{}
+        return nil
+    }
+
+    interrupted := make(chan os.Signal, 1)
+    signal.Notify(interrupted, os.Interrupt)
+
+    fmt.Printf("Watching %s (Ctrl-C to stop)\n", absPath)
+    var file *os.File
+    var fileInfo os.FileInfo
+    var pending []byte
+    readOffset := int64(0)
+    buf := make([]byte, 64*1024)
+
+    for {
+        if file == nil {
+            file, err = os.Open(absPath)
+            if err == nil {
+                fileInfo, _ = file.Stat()
+                inode := fileInode(fileInfo)
+                if watch.Offset > 0 && watch.Inode != 0 && inode != 0 && watch.Inode != inode {
+                    watch.Offset = 0
+                }
+                watch.Inode = inode
+                if watch.Offset < 0 || watch.Offset > fileInfo.Size() {
+                    if watch.Offset < 0 {
+                        watch.Offset = fileInfo.Size()
+                    } else {
+                        watch.Offset = 0
+                    }
+                }
+                readOffset = watch.Offset
+                file.Seek(readOffset, io.SeekStart)
+                pending = nil
+            } else {
+                file = nil
+            }
+        }
+
+        if file != nil {
+            for {
+                n, readErr := file.Read(buf)
+                if n > 0 {
+                    pending = append(pending, buf[:n]...)
+                    for {
+                        newline := bytes.IndexByte(pending, '\n')
+                        if newline < 0 {
+                            break
+                        }
+                        line := strings.TrimRight(string(pending[:newline]), "\r")
+                        pending = pending[newline+1:]
+                        readOffset += int64(newline + 1)
+                        if line != "" && (match == nil || match.MatchString(line)) {
+                            batch.add(line)
+                        }
+                    }
+                }
+                if readErr != nil || n == 0 {
+                    break
+                }
+            }
+            if batch.empty() {
+                watch.Offset = readOffset
+            }
+
+            // Detect rotation (a new file at the path) or truncation.
+            currentInfo, statErr := os.Stat(absPath)
+            rotated := statErr != nil || !os.SameFile(fileInfo, currentInfo)
+            truncated := statErr == nil && !rotated && currentInfo.Size() < readOffset
+            if rotated || truncated {
+                if err := flush(); err != nil {
+                    fmt.Println("Error sending message:", err)
+                }
+                if statErr == nil {
+                    file.Close()
+                    file = nil
+                    watch.Offset = 0
+                    if err := saveWatch(); err != nil {
+                        return err
+                    }
+                }
+            }
+        }
+
+        if !batch.empty() && time.Since(batch.started) >= window {
+            err := flush()
+            if err != nil {
+                fmt.Println("Error sending message:", err)
+            } else {
+                watch.Offset = readOffset
+                if err := saveWatch(); err != nil {
+                    return err
+                }
+            }
+        } else if batch.empty() {
+            if saved, exists := state.FileWatches[absPath]; !exists || saved.Offset != watch.Offset || saved.Inode != watch.Inode {
+                if err := saveWatch(); err != nil {
+                    return err
+                }
+            }
+        }
+
+        select {
+        case <-interrupted:
+            if err := flush(); err != nil {
+                fmt.Println("Error sending message:", err)
+            } else {
+                watch.Offset = readOffset
+            }
+            if file != nil {
+                file.Close()
+            }
+            fmt.Println("Stopped watching", absPath)
+            return saveWatch()
+        case <-time.After(time.Second):
+        }
+    }
+}
+
+// dedupeStateTTL is how long dedupe entries are kept after they were last
+// seen.
+const dedupeStateTTL = 7 * 24 * time.Hour
+
+func dedupeFooter(entry DedupeState) string {
+    return fmt.Sprintf("_:repeat: seen %d times, last at %s_", entry.Count, time.Unix(entry.LastSeen, 0).Format("2006-01-02 15:04:05"))
+}
+
+// sendDeduplicated posts message unless a message with the same key was
+// posted to the channel within window. Repeats either update the original
+// message with a counter and last-seen time (mode "update") or reply in its
+// thread (mode "thread").
+func sendDeduplicated(message, threadTS, key string, window time.Duration, mode string) error {
+    if mode != "update" && mode != "thread" {
+        return fmt.Errorf("invalid dedupe mode %q, use update or thread", mode)
+    }
+
+    channelID := currentChannelID()
+    stateKey := channelID + "/" + key
+    now := time.Now()
+
+    // Decide under the lock, talk to Slack after releasing it. A new entry
+    // is stored without a ts while its message is being posted; repeats
+    // arriving meanwhile are only counted.
+    var entry DedupeState
+    repeat := false
+    err := updateState(func() {
+        for storedKey, stored := range state.Dedupe {
+            if now.Sub(time.Unix(stored.LastSeen, 0)) > dedupeStateTTL {
+                delete(state.Dedupe, storedKey)
+            }
+        }
+
+        stored, exists := state.Dedupe[stateKey]
+        pendingExpired := exists && stored.Ts == "" && now.Sub(time.Unix(stored.FirstSeen, 0)) > time.Minute
+        if exists && !pendingExpired && now.Sub(time.Unix(stored.FirstSeen, 0)) < window {
+            stored.Count++
+            stored.LastSeen = now.Unix()
+            entry = *stored
+            repeat = true
+            return
+        }
+
+        if state.Dedupe == nil {
+            state.Dedupe = make(map[string]*DedupeState)
+        }
+        entry = DedupeState{
+            ChannelID: channelID,
+            Text:      message,
+            Count:     1,
+            FirstSeen: now.Unix(),
+            LastSeen:  now.Unix(),
+        }
+        saved := entry
+        state.Dedupe[stateKey] = &saved
+    })
+    if err != nil {
+        fmt.Println("Error sending message:", err)
+        return err
+    }
+
+    var result string
+    switch {
+    case repeat && entry.Ts == "":
+        result = fmt.Sprintf("Repeat #%d recorded, the first message is still being sent", entry.Count)
+    case repeat && mode == "thread":
+        reply := fmt.Sprintf("%s\n%s", message, dedupeFooter(entry))
+        _, err = postMessage(channelID, reply, entry.Ts)
+        result = fmt.Sprintf("Repeat #%d posted in thread %s", entry.Count, entry.Ts)
+    case repeat:
+        text := fmt.Sprintf("%s\n%s", entry.Text, dedupeFooter(entry))
+        err = editMessage(channelID, entry.Ts, text, config.SlackUserToken)
+        result = fmt.Sprintf("Message %s updated (seen %d times)", entry.Ts, entry.Count)
+    default:
+        err = postDedupeMessage(stateKey, channelID, message, threadTS, mode)
+        result = "Message sent successfully"
+    }
+    if err != nil {
+        fmt.Println("Error sending message:", err)
+        return err
+    }
+    fmt.Println(result)
+    return nil
+}
+
+// postDedupeMessage posts the first message for a dedupe key and records its
+// ts. Repeats counted while it was being posted are added to it afterwards.
+func postDedupeMessage(stateKey, channelID, message, threadTS, mode string) error {
+    ts, postErr := postMessage(channelID, message, threadTS)
+    var entry DedupeState
+    err := updateState(func() {
+        stored, exists := state.Dedupe[stateKey]
+        if !exists {
+            return
+        }
+        if postErr != nil {
+            delete(state.Dedupe, stateKey)
+            return
+        }
+        stored.Ts = ts
+        entry = *stored
+    })
+    if postErr != nil {
+        return postErr
+    }
+    if err != nil {
+        return err
+    }
+    if entry.Count > 1 && mode == "update" {
+        text := fmt.Sprintf("%s\n%s", entry.Text, dedupeFooter(entry))
+        return editMessage(channelID, ts, text, config.SlackUserToken)
+    }
+    return nil
+}
+
+// outboxEventType marks messages posted from the outbox in their metadata,
+// so a retry can check whether an earlier attempt reached Slack.
+const outboxEventType = "slack_cli_outbox"
+
+// outboxClaimDuration is how long a flushing process owns an entry before
+// another process may retry it.
+const outboxClaimDuration = 2 * time.Minute
+
+func newOutboxID() string {
+    randomBytes := make([]byte, 8)
+    rand.Read(randomBytes)
+    return hex.EncodeToString(randomBytes)
+}
+
+// deliverOutboxEntry posts an outbox entry with its ID in the message
+// metadata.
+func deliverOutboxEntry(entry OutboxEntry) (string, error) {
+    payload := map[string]interface{}{
+        "channel": entry.ChannelID,
+        "text":    entry.Text,
+        "metadata": map[string]interface{}{
+            "event_type": outboxEventType,
+            "event_payload": map[string]string{
+                "id": entry.ID,
+            },
+        },
+    }
+    if entry.ThreadTS != "" {
+        payload["thread_ts"] = entry.ThreadTS
+    }
+
+    var response struct {
+        Ts string `json:"ts"`
+    }
+    err := slackAPIPost("chat.postMessage", payload, config.SlackUserToken, &response)
+    return response.Ts, err
+}
+
+// findDeliveredOutboxEntry looks for a message carrying the entry's ID, which
+// means an earlier attempt was delivered even though it looked failed. It
+// pages through everything posted since the entry was queued.
+func findDeliveredOutboxEntry(entry OutboxEntry) (string, error) {
+    method := "conversations.history"
+    if entry.ThreadTS != "" {
+        method = "conversations.replies"
+    }
+    cursor := ""
+    for {
+        params := url.Values{}
+        params.Set("channel", entry.ChannelID)
+        params.Set("oldest", strconv.FormatInt(entry.CreatedAt-60, 10))
+        params.Set("include_all_metadata", "true")
+        params.Set("limit", "200")
+        if entry.ThreadTS != "" {
+            params.Set("ts", entry.ThreadTS)
+        }
+        if cursor != "" {
+            params.Set("cursor", cursor)
+        }
+
+        var response struct {
+            Messages []struct {
+                Ts       string `json:"ts"`
+                Metadata struct {
+                    EventType    string `json:"event_type"`
+                    EventPayload struct {
+                        ID string `json:"id"`
+                    } `json:"event_payload"`
+                } `json:"metadata"`
+            } `json:"messages"`
+            ResponseMetadata struct {
+                NextCursor string `json:"next_cursor"`
+            } `json:"response_metadata"`
+        }
+        err := slackAPIGet(method, params, config.SlackUserToken, &response)
+        if err != nil {
+            return "", err
+        }
+        for _, message := range response.Messages {
+            if message.Metadata.EventType == outboxEventType && message.Metadata.EventPayload.ID == entry.ID {
+                return message.Ts, nil
+            }
+        }
+        cursor = response.ResponseMetadata.NextCursor
+        if cursor == "" {
+            return "", nil
+        }
+    }
+}
+
+// sendQueued delivers a message through the outbox: the entry is stored
+// before the first attempt and removed once Slack accepted it, so a failed
+// or interrupted send is retried by the next flush.
+func sendQueued(message, threadTS string) error {
+    entry := OutboxEntry{
+        ID:        newOutboxID(),
+        ChannelID: currentChannelID(),
+        ThreadTS:  threadTS,
+        Text:      message,
+        CreatedAt: time.Now().Unix(),
+        Attempts:  1,
+        Claimed:   time.Now().Add(outboxClaimDuration).Unix(),
+    }
+    err := updateState(func() {
+        state.Outbox = append(state.Outbox, &entry)
+    })
+    if err != nil {
+        return err
+    }
+
+    _, sendErr := deliverOutboxEntry(entry)
+    err = updateState(func() {
+        for i, queued := range state.Outbox {
+            if queued.ID != entry.ID {
+                continue
+            }
+            if sendErr == nil {
+                state.Outbox = append(state.Outbox[:i], state.Outbox[i+1:]...)
+            } else {
+                queued.LastError = sendErr.Error()
+                queued.Claimed = 0
+            }
+            break
+        }
+    })
+    if err != nil {
+        return err
+    }
+
+    if sendErr != nil {
+        fmt.Printf("Delivery failed (%v), message queued in outbox as %s\n", sendErr, entry.ID)
+        return nil
+    }
+    fmt.Println("Message sent successfully")
+    return nil
+}
+
+// localCommands never talk to Slack. With the outbox commands, which flush
+// on their own, and shell help they run without flushing the outbox.
+var localCommands = map[string]bool{
+    "slack examples":       true,
+    "slack help":           true,
+    "slack completion":     true,
+    "slack __complete":     true,
+    "slack outbox":         true,
+    "slack status presets": true,
+    "slack job list":       true,
+}
+
+// flushesOutbox reports whether cmd flushes the outbox before it runs: only
+// commands that talk to Slack do, and not for a --preview or --dry-run.
+func flushesOutbox(cmd *cobra.Command) bool {
+    for parent := cmd; parent != nil; parent = parent.Parent() {
+        if localCommands[parent.CommandPath()] {
+            return false
+        }
+    }
+    for _, name := range []string{"preview", "dry-run"} {
+        if value, err := cmd.Flags().GetBool(name); err == nil && value {
+            return false
+        }
+    }
+    return true
+}
+
+// flushOutbox retries queued messages in order. Entries that were attempted
+// before are first looked up in the channel, so a message that reached Slack
+// despite an error is never posted twice.
+func flushOutbox(quiet bool) error {
+    err := loadState()
+    if err != nil {
+        return err
+    }
+    if len(state.Outbox) == 0 {
+        if !quiet {
+            fmt.Println("Outbox is empty")
+        }
+        return nil
+    }
+
+    // Each entry is tried at most once per flush, so a flush always ends even
+    // when every attempt fails.
+    attempted := make(map[string]bool)
+    delivered, failed := 0, 0
+    for {
+        var entry OutboxEntry
+        found := false
+        now := time.Now().Unix()
+        err := updateState(func() {
+            for _, queued := range state.Outbox {
+                if queued.Claimed > now || attempted[queued.ID] {
+                    continue
+                }
+                queued.Claimed = time.Now().Add(outboxClaimDuration).Unix()
+                queued.Attempts++
+                entry = *queued
+                found = true
+                attempted[queued.ID] = true
+                return
+            }
+        })
+        if err != nil {
+            return err
+        }
+        if !found {
+            break
+        }
+
+        ts := ""
+        var sendErr error
+        if entry.Attempts > 1 {
+            ts, sendErr = findDeliveredOutboxEntry(entry)
+        }
+        if sendErr == nil && ts == "" {
+            ts, sendErr = deliverOutboxEntry(entry)
+        }
+
+        err = updateState(func() {
+            for i, queued := range state.Outbox {
+                if queued.ID != entry.ID {
+                    continue
+                }
+                if sendErr == nil {
+                    state.Outbox = append(state.Outbox[:i], state.Outbox[i+1:]...)
+                } else {
+                    queued.LastError = sendErr.Error()
+                    queued.Claimed = 0
+                }
+                return
+            }
+        })
+        if err != nil {
+            return err
+        }
+
+        if sendErr != nil {
+            failed++
+            fmt.Printf("Outbox: %s to %s failed: %v\n", entry.ID, channelDisplayName(entry.ChannelID), sendErr)
+        } else {
+            delivered++
+            fmt.Printf("Outbox: %s delivered to %s (ts %s)\n", entry.ID, channelDisplayName(entry.ChannelID), ts)
+        }
+    }
+
+    if !quiet || delivered > 0 || failed > 0 {
+        fmt.Printf("Outbox: %d delivered, %d failed, %d queued\n", delivered, failed, len(state.Outbox))
+    }
+    return nil
+}
+
+func listOutbox() error {
+    err := loadState()
+    if err != nil {
+        return err
+    }
+    if len(state.Outbox) == 0 {
+        fmt.Println("Outbox is empty")
+        return nil
+    }
+    writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(writer, "ID\tQUEUED\tCHANNEL\tATTEMPTS\tLAST ERROR\tTEXT")
+    for _, entry := range state.Outbox {
+        text := strings.ReplaceAll(entry.Text, "\n", " ")
+        if len([]rune(text)) > 40 {
+            text = string([]rune(text)[:40]) + "..."
+        }
+        fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%s\t%s\n", entry.ID, time.Unix(entry.CreatedAt, 0).Format("2006-01-02 15:04:05"), channelDisplayName(entry.ChannelID), entry.Attempts, entry.LastError, text)
+    }
+    writer.Flush()
+    return nil
+}
+
+// dropOutbox removes the given entries, or all of them when ids is empty.
+func dropOutbox(ids []string) error {
+    dropped := 0
+    err := updateState(func() {
+        var kept []*OutboxEntry
+        for _, entry := range state.Outbox {
+            drop := len(ids) == 0
+            for _, id := range ids {
+                if entry.ID == id {
+                    drop = true
+                }
+            }
+            if drop {
+                dropped++
+            } else {
+                kept = append(kept, entry)
+            }
+        }
+        state.Outbox = kept
+    })
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Dropped %d messages from the outbox\n", dropped)
+    return nil
+}
+
+// maxScheduleAhead is how far in the future chat.scheduleMessage accepts a
+// post time.
+const maxScheduleAhead = 120 * 24 * time.Hour
+
+// configLocation returns the time zone from the config, or the local time
+// zone when none is set.
+func configLocation() (*time.Location, error) {
+    if config.TimeZone == "" {
+        return time.Local, nil
+    }
+    location, err := time.LoadLocation(config.TimeZone)
+    if err != nil {
+        return nil, fmt.Errorf("invalid time_zone %q in %s: %v", config.TimeZone, configFileName, err)
+    }
+    return location, nil
+}
+
+// parseScheduleTime turns --at ("YYYY-MM-DD HH:MM" or "HH:MM", in the
+// configured time zone) or --in (a duration such as 2h or 1d) into a post
+// time.
+func parseScheduleTime(at, in string, now time.Time) (time.Time, error) {
+    if at != "" && in != "" {
+        return time.Time{}, fmt.Errorf("use either --at or --in")
+    }
+
+    var postAt time.Time
+    if in != "" {
+        duration, err := parseDuration(in)
+        if err != nil {
+            return time.Time{}, err
+        }
+        postAt = now.Add(duration)
+    } else {
+        location, err := configLocation()
+        if err != nil {
+            return time.Time{}, err
+        }
+        parsed, err := time.ParseInLocation("2006-01-02 15:04", at, location)
+        if err != nil {
+            clock, clockErr := time.ParseInLocation("15:04", at, location)
+            if clockErr != nil {
+                return time.Time{}, fmt.Errorf("invalid time %q, use \"YYYY-MM-DD HH:MM\" or HH:MM", at)
+            }
+            today := now.In(location)
+            parsed = time.Date(today.Year(), today.Month(), today.Day(), clock.Hour(), clock.Minute(), 0, 0, location)
+            if !parsed.After(now) {
+                parsed = parsed.AddDate(0, 0, 1)
+            }
+        }
+        postAt = parsed
+    }
+
+    if !postAt.After(now) {
+        return time.Time{}, fmt.Errorf("%s is in the past", postAt.Format("2006-01-02 15:04 MST"))
+    }
+    if postAt.Sub(now) > maxScheduleAhead {
+        return time.Time{}, fmt.Errorf("Slack only schedules messages up to 120 days ahead")
+    }
+    return postAt, nil
+}
+
+// scheduleMessage schedules text to be posted at postAt and returns the
+// scheduled message ID.
+func scheduleMessage(channelID, text, threadTS string, postAt time.Time) (string, error) {
+    payload := map[string]interface{}{
+        "channel": channelID,
+        "text":    text,
+        "post_at": postAt.Unix(),
+    }
+    if threadTS != "" {
+        payload["thread_ts"] = threadTS
+    }
+
+    var response struct {
+        ScheduledMessageID string `json:"scheduled_message_id"`
+    }
+    err := slackAPIPost("chat.scheduleMessage", payload, config.SlackUserToken, &response)
+    return response.ScheduledMessageID, err
+}
+
+func sendScheduled(message, threadTS string, postAt time.Time) error {
+    id, err := scheduleMessage(currentChannelID(), message, threadTS, postAt)
+    if err != nil {
+        fmt.Println("Error scheduling message:", err)
+        return err
+    }
+
+    location, err := configLocation()
+    if err != nil {
+        location = time.Local
+    }
+    fmt.Printf("Message scheduled for %s (id %s)\n", postAt.In(location).Format("2006-01-02 15:04 MST"), id)
+    return nil
+}
+
+type ScheduledMessage struct {
+    ID          string `json:"id"`
+    ChannelID   string `json:"channel_id"`
+    PostAt      int64  `json:"post_at"`
+    DateCreated int64  `json:"date_created"`
+    Text        string `json:"text"`
+}
+
+// getScheduledMessages lists pending scheduled messages, in all channels when
+// channelID is empty.
+func getScheduledMessages(channelID string) ([]ScheduledMessage, error) {
+    var messages []ScheduledMessage
+    cursor := ""
+    for {
+        payload := map[string]interface{}{
+            "limit": 100,
+        }
+        if channelID != "" {
+            payload["channel"] = channelID
+        }
+        if cursor != "" {
+            payload["cursor"] = cursor
+        }
+
+        var response struct {
+            ScheduledMessages []ScheduledMessage `json:"scheduled_messages"`
+            ResponseMetadata  struct {
+                NextCursor string `json:"next_cursor"`
+            } `json:"response_metadata"`
+        }
+        err := slackAPIPost("chat.scheduledMessages.list", payload, config.SlackUserToken, &response)
+        if err != nil {
+            return nil, err
+        }
+        messages = append(messages, response.ScheduledMessages...)
+        cursor = response.ResponseMetadata.NextCursor
+        if cursor == "" {
+            break
+        }
+    }
+    sort.Slice(messages, func(i, j int) bool {
+        return messages[i].PostAt < messages[j].PostAt
+    })
+    return messages, nil
+}
+
+func listScheduledMessages() error {
+    messages, err := getScheduledMessages(targetChannelID)
+    if err != nil {
+        return err
+    }
+    if len(messages) == 0 {
+        fmt.Println("No scheduled messages")
+        return nil
+    }
+
+    location, err := configLocation()
+    if err != nil {
+        return err
+    }
+    writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(writer, "ID\tPOST AT\tCHANNEL\tTEXT")
+    for _, message := range messages {
+        text := strings.ReplaceAll(message.Text, "\n", " ")
+        if len([]rune(text)) > 50 {
+            text = string([]rune(text)[:50]) + "..."
+        }
+        postAt := time.Unix(message.PostAt, 0).In(location).Format("2006-01-02 15:04 MST")
+        fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", message.ID, postAt, channelDisplayName(message.ChannelID), text)
+    }
+    writer.Flush()
+    return nil
+}
+
+// deleteScheduledMessage cancels a scheduled message. The channel is looked
+// up from the scheduled list, since the API needs it alongside the ID.
+func deleteScheduledMessage(id string) error {
+    channelID := targetChannelID
+    if channelID == "" {
+        messages, err := getScheduledMessages("")
+        if err != nil {
+            return err
+        }
+        for _, message := range messages {
+            if message.ID == id {
+                channelID = message.ChannelID
+                break
+            }
+        }
+        if channelID == "" {
+            return fmt.Errorf("no scheduled message with id %s", id)
+        }
+    }
+
+    payload := map[string]string{
+        "channel":              channelID,
+        "scheduled_message_id": id,
+    }
+    err := slackAPIPost("chat.deleteScheduledMessage", payload, config.SlackUserToken, nil)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Scheduled message %s deleted\n", id)
+    return nil
+}
+
+// cronSchedule is a parsed five-field cron expression. Each field is a bit
+// set of the allowed values.
+type cronSchedule struct {
+    minute, hour, dayOfMonth, month, dayOfWeek uint64
+    anyDayOfMonth, anyDayOfWeek                bool
+}
+
+var cronMacros = map[string]string{
+    "@yearly":   "0 0 1 1 *",
+    "@annually": "0 0 1 1 *",
+    "@monthly":  "0 0 1 * *",
+    "@weekly":   "0 0 * * 0",
+    "@daily":    "0 0 * * *",
+    "@midnight": "0 0 * * *",
+    "@hourly":   "0 * * * *",
+}
+
+var cronMonthNames = map[string]int{
+    "jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+    "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronDayNames = map[string]int{
+    "sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// parseCron parses "min hour day-of-month month day-of-week" with *, lists,
+// ranges, steps and month/day names, or one of the @daily style macros.
+func parseCron(spec string) (cronSchedule, error) {
+    if expanded, exists := cronMacros[spec]; exists {
+        spec = expanded
+    }
+    fields := strings.Fields(spec)
+    if len(fields) != 5 {
+        return cronSchedule{}, fmt.Errorf("invalid cron expression %q: expected 5 fields", spec)
+    }
+
+    var schedule cronSchedule
+    var err error
+    if schedule.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+        return cronSchedule{}, err
+    }
+    if schedule.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+        return cronSchedule{}, err
+    }
+    if schedule.dayOfMonth, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+        return cronSchedule{}, err
+    }
+    if schedule.month, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+        return cronSchedule{}, err
+    }
+    if schedule.dayOfWeek, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+        return cronSchedule{}, err
+    }
+    // Both 0 and 7 mean Sunday.
+    if schedule.dayOfWeek&(1<<7) != 0 {
+        schedule.dayOfWeek |= 1
+    }
+    schedule.anyDayOfMonth = strings.HasPrefix(fields[2], "*")
+    schedule.anyDayOfWeek = strings.HasPrefix(fields[4], "*")
+    return schedule, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+    parseValue := func(value string) (int, error) {
+        if number, exists := names[strings.ToLower(value)]; exists {
+            return number, nil
+        }
+        number, err := strconv.Atoi(value)
+        if err != nil || number < min || number > max {
+            return 0, fmt.Errorf("invalid cron value %q (allowed %d-%d)", value, min, max)
+        }
+        return number, nil
+    }
+
+    var bits uint64
+    for _, part := range strings.Split(field, ",") {
+        step := 1
+        if index := strings.Index(part, "/"); index >= 0 {
+            parsed, err := strconv.Atoi(part[index+1:])
+            if err != nil || parsed < 1 {
+                return 0, fmt.Errorf("invalid cron step in %q", part)
+            }
+            step = parsed
+            part = part[:index]
+        }
+
+        low, high := min, max
+        if part != "*" {
+            bounds := strings.SplitN(part, "-", 2)
+            var err error
+            if low, err = parseValue(bounds[0]); err != nil {
+                return 0, err
+            }
+            high = low
+            if len(bounds) == 2 {
+                if high, err = parseValue(bounds[1]); err != nil {
+                    return 0, err
+                }
+            } else if step > 1 {
+                high = max
+            }
+            if high < low {
+                return 0, fmt.Errorf("invalid cron range %q", part)
+            }
+        }
+        for value := low; value <= high; value += step {
+            bits |= 1 << uint(value)
+        }
+    }
+    return bits, nil
+}
+
+// matchesDay follows cron's rule that when both day fields are restricted, a
+// day matching either one runs.
+func (schedule cronSchedule) matchesDay(t time.Time) bool {
+    dayOfMonth := schedule.dayOfMonth&(1<<uint(t.Day())) != 0
+    dayOfWeek := schedule.dayOfWeek&(1<<uint(t.Weekday())) != 0
+    if schedule.anyDayOfMonth || schedule.anyDayOfWeek {
+        return dayOfMonth && dayOfWeek
+    }
+    return dayOfMonth || dayOfWeek
+}
+
+// next returns the first matching minute after t, in t's location, or the
+// zero time when there is none within five years.
+func (schedule cronSchedule) next(t time.Time) time.Time {
+    t = t.Truncate(time.Minute).Add(time.Minute)
+    limit := t.AddDate(5, 0, 0)
+    for t.Before(limit) {
+        if schedule.month&(1<<uint(t.Month())) == 0 || !schedule.matchesDay(t) {
+            t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+            continue
+        }
+        if schedule.hour&(1<<uint(t.Hour())) == 0 {
+            t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+            continue
+        }
+        if schedule.minute&(1<<uint(t.Minute())) == 0 {
+            t = t.Add(time.Minute)
+            continue
+        }
+        return t
+    }
+    return time.Time{}
+}
+
+// schedulerMaxCatchUp limits how many missed runs of one entry are posted
+// with --catch-up all.
+const schedulerMaxCatchUp = 24
+
+// ScheduleEntry is one line of a scheduler file.
+type ScheduleEntry struct {
+    Key       string
+    Line      int
+    Spec      string
+    Channel   string
+    ChannelID string
+    Message   *template.Template
+    Schedule  cronSchedule
+}
+
+// ScheduleData is passed to scheduler message templates. Time is the
+// scheduled run time, which differs from the current time for catch-up runs.
+type ScheduleData struct {
+    Time    time.Time
+    Channel string
+}
+
+// parseScheduleFile reads lines of the form
+//
+//     <cron expression> <channel> <message template>
+//
+// where the channel is anything resolveChannel accepts and \n in the
+// message starts a new line. Blank lines and lines starting with # are
+// ignored.
+func parseScheduleFile(filePath string) ([]*ScheduleEntry, error) {
+    data, err := os.ReadFile(filePath)
+    if err != nil {
+        return nil, err
+    }
+
+    var entries []*ScheduleEntry
+    for index, line := range strings.Split(string(data), "\n") {
+        line = strings.TrimSpace(line)
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        lineNumber := index + 1
+        fields := strings.Fields(line)
+        specFields := 5
+        if strings.HasPrefix(fields[0], "@") {
+            specFields = 1
+        }
+        if len(fields) < specFields+2 {
+            return nil, fmt.Errorf("%s:%d: expected <cron expression> <channel> <message>", filePath, lineNumber)
+        }
+
+        spec := strings.Join(fields[:specFields], " ")
+        schedule, err := parseCron(spec)
+        if err != nil {
+            return nil, fmt.Errorf("%s:%d: %v", filePath, lineNumber, err)
+        }
+        channel := fields[specFields]
+        // The message is the rest of the line with its spacing kept.
+        rest := line
+        for _, field := range fields[:specFields+1] {
+            rest = strings.TrimSpace(rest[strings.Index(rest, field)+len(field):])
+        }
+        message, err := template.New(fmt.Sprintf("line %d", lineNumber)).Funcs(templateFuncs(false)).Parse(strings.ReplaceAll(rest, `\n`, "\n"))
+        if err != nil {
+            return nil, fmt.Errorf("%s:%d: %v", filePath, lineNumber, err)
+        }
+        channelID, err := resolveChannel(channel)
+        if err != nil {
+            return nil, fmt.Errorf("%s:%d: %v", filePath, lineNumber, err)
+        }
+
+        hash := sha1.Sum([]byte(spec + "\x00" + channelID + "\x00" + rest))
+        entries = append(entries, &ScheduleEntry{
+            Key:       hex.EncodeToString(hash[:8]),
+            Line:      lineNumber,
+            Spec:      spec,
+            Channel:   channel,
+            ChannelID: channelID,
+            Message:   message,
+            Schedule:  schedule,
+        })
+    }
+    return entries, nil
+}
+
+// runScheduleEntry posts one run of an entry through the normal send path.
+func runScheduleEntry(entry *ScheduleEntry, runTime time.Time) error {
+    var rendered bytes.Buffer
+    err := entry.Message.Execute(&rendered, ScheduleData{Time: runTime, Channel: entry.Channel})
+    if err != nil {
+        return err
+    }
+    if strings.TrimSpace(rendered.String()) == "" {
+        return fmt.Errorf("message is empty")
+    }
+    previous := targetChannelID
+    targetChannelID = entry.ChannelID
+    defer func() { targetChannelID = previous }()
+    return sendMessage(rendered.String(), "")
+}
+
+// scheduleRuns returns the runs of schedule after last and up to now that
+// are to be posted under the catchUp policy, and how many were skipped. A
+// run at exactly now is never a missed run, so skip still posts it.
+func scheduleRuns(schedule cronSchedule, last, now time.Time, catchUp string) ([]time.Time, int) {
+    var due []time.Time
+    for runTime := schedule.next(last); !runTime.IsZero() && !runTime.After(now); runTime = schedule.next(runTime) {
+        due = append(due, runTime)
+    }
+    if len(due) == 0 {
+        return nil, 0
+    }
+
+    runs := due
+    switch catchUp {
+    case "skip":
+        runs = nil
+        if due[len(due)-1].Equal(now) {
+            runs = due[len(due)-1:]
+        }
+    case "last":
+        runs = due[len(due)-1:]
+    }
+    if len(runs) > schedulerMaxCatchUp {
+        runs = runs[len(runs)-schedulerMaxCatchUp:]
+    }
+    return runs, len(due) - len(runs)
+}
+
+// runDueScheduleEntries posts the entries due at now. Runs missed while the
+// scheduler was not running are handled by catchUp: skip drops them, last
+// posts only the most recent one and all posts each of them. The last-run
+// time is saved before posting, so a crash never causes a duplicate. Runs
+// whose post fails are kept and retried on the next call.
+func runDueScheduleEntries(entries []*ScheduleEntry, now time.Time, catchUp string) error {
+    err := loadState()
+    if err != nil {
+        return err
+    }
+
+    // Entries seen for the first time are recorded even when they are not
+    // due, so runs missed during a later outage can be caught up.
+    var unseen []string
+    for _, entry := range entries {
+        last := now.Add(-time.Minute)
+        var pending []time.Time
+        saved, seen := state.Schedules[entry.Key]
+        if seen {
+            last = time.Unix(saved.LastRun, 0).In(now.Location())
+            for _, retry := range saved.Retry {
+                pending = append(pending, time.Unix(retry, 0).In(now.Location()))
+            }
+        }
+        runs, skipped := scheduleRuns(entry.Schedule, last, now, catchUp)
+        if len(runs) == 0 && skipped == 0 && len(pending) == 0 {
+            if !seen {
+                unseen = append(unseen, entry.Key)
+            }
+            continue
+        }
+        if skipped > 0 {
+            fmt.Printf("%s line %d: skipped %d missed runs\n", now.Format("2006-01-02 15:04"), entry.Line, skipped)
+        }
+        pending = append(pending, runs...)
+
+        err := updateState(func() {
+            if state.Schedules == nil {
+                state.Schedules = make(map[string]*ScheduleRunState)
+            }
+            state.Schedules[entry.Key] = &ScheduleRunState{LastRun: now.Unix()}
+        })
+        if err != nil {
+            return err
+        }
+
+        var failed []int64
+        for _, runTime := range pending {
+            err := runScheduleEntry(entry, runTime)
+            if err != nil {
+                fmt.Printf("%s line %d: not sent to %s, will retry: %v\n", now.Format("2006-01-02 15:04"), entry.Line, entry.Channel, err)
+                failed = append(failed, runTime.Unix())
+                continue
+            }
+            fmt.Printf("%s line %d: sent to %s (scheduled %s)\n", now.Format("2006-01-02 15:04"), entry.Line, entry.Channel, runTime.Format("2006-01-02 15:04"))
+        }
+        if len(failed) > schedulerMaxCatchUp {
+            failed = failed[len(failed)-schedulerMaxCatchUp:]
+        }
+        if len(failed) > 0 {
+            err := updateState(func() {
+                if saved, exists := state.Schedules[entry.Key]; exists {
+                    saved.Retry = failed
+                }
+            })
+            if err != nil {
+                return err
+            }
+        }
+    }
+
+    if len(unseen) == 0 {
+        return nil
+    }
+    return updateState(func() {
+        if state.Schedules == nil {
+            state.Schedules = make(map[string]*ScheduleRunState)
+        }
+        for _, key := range unseen {
+            state.Schedules[key] = &ScheduleRunState{LastRun: now.Unix()}
+        }
+    })
+}
+
+func printScheduleEntries(entries []*ScheduleEntry, now time.Time) {
+    writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(writer, "LINE\tSCHEDULE\tCHANNEL\tNEXT RUN")
+    for _, entry := range entries {
+        nextRun := "never"
+        if runTime := entry.Schedule.next(now); !runTime.IsZero() {
+            nextRun = runTime.Format("2006-01-02 15:04 MST")
+        }
+        fmt.Fprintf(writer, "%d\t%s\t%s\t%s\n", entry.Line, entry.Spec, entry.Channel, nextRun)
+    }
+    writer.Flush()
+}
+
+// runScheduler posts the messages of a scheduler file in the foreground,
+// checking once a minute. The file is read again when it changes.
+func runScheduler(filePath, catchUp string, dryRun bool) error {
+    if catchUp != "skip" && catchUp != "last" && catchUp != "all" {
+        return fmt.Errorf("invalid --catch-up %q, use skip, last or all", catchUp)
+    }
+    location, err := configLocation()
+    if err != nil {
+        return err
+    }
+    entries, err := parseScheduleFile(filePath)
+    if err != nil {
+        return err
+    }
+    if dryRun {
+        printScheduleEntries(entries, time.Now().In(location))
+        return nil
+    }
+    fileInfo, err := os.Stat(filePath)
+    if err != nil {
+        return err
+    }
+
+    interrupted := make(chan os.Signal, 1)
+    signal.Notify(interrupted, os.Interrupt)
+
+    fmt.Printf("Scheduler running with %d entries from %s (Ctrl-C to stop)\n", len(entries), filePath)
+    for {
+        if currentInfo, err := os.Stat(filePath); err == nil && !currentInfo.ModTime().Equal(fileInfo.ModTime()) {
+            fileInfo = currentInfo
+            reloaded, err := parseScheduleFile(filePath)
+            if err != nil {
+                fmt.Println("Error reloading schedule, keeping the previous entries:", err)
+            } else {
+                entries = reloaded
+                fmt.Printf("Reloaded %d entries from %s\n", len(entries), filePath)
+            }
+        }
+
+        // Each tick looks the users up afresh.
+        fetchedUsers = nil
+        now := time.Now().In(location).Truncate(time.Minute)
+        err := runDueScheduleEntries(entries, now, catchUp)
+        if err != nil {
+            fmt.Println("Error running schedule:", err)
+        }
+
+        wait := time.Until(now.Add(time.Minute)) + time.Second
+        select {
+        case <-interrupted:
+            return nil
+        case <-time.After(wait):
+        }
+    }
+}
+
+// TemplateText is a message template in the config, written either as one
+// string or as a list of lines.
+type TemplateText string
+
+func (text *TemplateText) UnmarshalJSON(data []byte) error {
+    var lines []string
+    if err := json.Unmarshal(data, &lines); err == nil {
+        *text = TemplateText(strings.Join(lines, "\n"))
+        return nil
+    }
+    var value string
+    if err := json.Unmarshal(data, &value); err != nil {
+        return fmt.Errorf("template must be a string or a list of lines")
+    }
+    *text = TemplateText(value)
+    return nil
+}
+
+// MarshalJSON writes multi-line templates back as a list of lines so that
+// saving the config keeps them readable.
+func (text TemplateText) MarshalJSON() ([]byte, error) {
+    if strings.Contains(string(text), "\n") {
+        return json.Marshal(strings.Split(string(text), "\n"))
+    }
+    return json.Marshal(string(text))
+}
+
+// templateFuncs are the helpers available in message templates. With
+// preview set, mention and channel print the name instead of looking it up,
+// since resolving a DM channel opens the conversation.
+func templateFuncs(preview bool) template.FuncMap {
+    return template.FuncMap{
+        "now": func() time.Time {
+            location, err := configLocation()
+            if err != nil {
+                location = time.Local
+            }
+            return time.Now().In(location)
+        },
+        "date": func(layout string, times ...time.Time) string {
+            t := time.Now()
+            if len(times) > 0 {
+                t = times[0]
+            } else if location, err := configLocation(); err == nil {
+                t = t.In(location)
+            }
+            return t.Format(layout)
+        },
+        "addDays": func(days int, t time.Time) time.Time {
+            return t.AddDate(0, 0, days)
+        },
+        "mention": func(name string) (string, error) {
+            if preview {
+                return "@" + strings.TrimPrefix(name, "@"), nil
+            }
+            userID, err := findUserID(strings.TrimPrefix(name, "@"))
+            if err != nil {
+                return "", err
+            }
+            return "<@" + userID + ">", nil
+        },
+        "channel": func(name string) (string, error) {
+            if preview {
+                if strings.HasPrefix(name, "@") {
+                    return name, nil
+                }
+                return "#" + strings.TrimPrefix(name, "#"), nil
+            }
+            channelID, err := resolveChannel(name)
+            if err != nil {
+                return "", err
+            }
+            return "<#" + channelID + ">", nil
+        },
+    }
+}
+
+// templateVariables merges template variables: environment variables first,
+// then the JSON file, then --var k=v, each overriding the one before.
+func templateVariables(vars []string, varsFile string) (map[string]interface{}, error) {
+    variables := make(map[string]interface{})
+    for _, entry := range os.Environ() {
+        if index := strings.Index(entry, "="); index > 0 {
+            variables[entry[:index]] = entry[index+1:]
+        }
+    }
+
+    if varsFile != "" {
+        data, err := os.ReadFile(varsFile)
+        if err != nil {
+            return nil, err
+        }
+        var fileVariables map[string]interface{}
+        err = json.Unmarshal(data, &fileVariables)
+        if err != nil {
+            return nil, fmt.Errorf("invalid JSON in %s: %v", varsFile, err)
+        }
+        for key, value := range fileVariables {
+            variables[key] = value
+        }
+    }
+
+    for _, entry := range vars {
+        index := strings.Index(entry, "=")
+        if index <= 0 {
+            return nil, fmt.Errorf("invalid --var %q, use key=value", entry)
+        }
+        variables[entry[:index]] = entry[index+1:]
+    }
+    return variables, nil
+}
+
+// renderTemplate renders the named template from the config. Preview
+// rendering makes no Slack calls.
+func renderTemplate(name string, variables map[string]interface{}, preview bool) (string, error) {
+    text, exists := config.Templates[name]
+    if !exists {
+        var names []string
+        for templateName := range config.Templates {
+            names = append(names, templateName)
+        }
+        sort.Strings(names)
+        if len(names) == 0 {
+            return "", fmt.Errorf("template %s not found, no templates are defined in %s", name, configFileName)
+        }
+        return "", fmt.Errorf("template %s not found, available: %s", name, strings.Join(names, ", "))
+    }
+
+    parsed, err := template.New(name).Funcs(templateFuncs(preview)).Option("missingkey=error").Parse(string(text))
+    if err != nil {
+        return "", err
+    }
+    var rendered bytes.Buffer
+    err = parsed.Execute(&rendered, variables)
+    if err != nil {
+        return "", err
+    }
+    return strings.TrimRight(rendered.String(), "\n"), nil
+}
+
+// mrkdwnLine is one converted line. Headers and rules are kept apart so
+// they can become their own blocks with --sections; header holds the plain
+// header text and source the Markdown of a paragraph line.
+type mrkdwnLine struct {
+    text   string
+    header string
+    source string
+    rule   bool
+    code   bool
+}
+
+var (
+    markdownHeaderPattern  = regexp.MustCompile(`^ {0,3}(#{1,6})\s+(.*?)\s*#*\s*$`)
+    markdownListPattern    = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+    markdownTaskPattern    = regexp.MustCompile(`^\[([ xX])\]\s+(.*)$`)
+    markdownRulePattern    = regexp.MustCompile(`^ {0,3}((-\s*){3,}|(\*\s*){3,}|(_\s*){3,})$`)
+    markdownSetextPattern  = regexp.MustCompile(`^ {0,3}(=+|-+)\s*$`)
+    markdownTableSeparator = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+    markdownLinkPattern    = regexp.MustCompile(`!?\[([^\]]*)\]\(\s*<?([^)\s>]+)>?(?:\s+"[^"]*")?\s*\)`)
+    markdownAutoLink       = regexp.MustCompile(`<((?:https?://|mailto:)[^>\s]+|[@#!][^>\s]+)>`)
+    markdownBoldPattern    = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*|__(\S(?:.*?\S)?)__`)
+    markdownItalicPattern  = regexp.MustCompile(`\*(\S(?:[^*]*?\S)?)\*`)
+    markdownStrikePattern  = regexp.MustCompile(`~~(\S(?:.*?\S)?)~~`)
+
+    // Only underscores at word boundaries are emphasis.
+    markdownUnderscorePattern = regexp.MustCompile(`(^|[^\p{L}\p{N}_])_(\S(?:[^_]*?\S)?)_($|[^\p{L}\p{N}_])`)
+)
+
+var markdownBullets = []string{"•", "◦", "▪"}
+
+// markdownInline converts inline Markdown (emphasis, links, code spans) to
+// mrkdwn and escapes the rest of the text for Slack.
+func markdownInline(text string) string {
+    var result strings.Builder
+    parts := strings.Split(text, "`")
+    for i, part := range parts {
+        // Odd parts are code spans, as long as the backtick is closed.
+        if i%2 == 1 && i < len(parts)-1 {
+            result.WriteString("`" + escapeSlackText(part) + "`")
+            continue
+        }
+        if i%2 == 1 {
+            result.WriteString("`")
+        }
+
+        var saved []string
+        placeholder := func(value string) string {
+            saved = append(saved, value)
+            return fmt.Sprintf("\x00%d\x00", len(saved)-1)
+        }
+        // URLs are escaped too: Slack reads "&" in them as an entity.
+        part = markdownLinkPattern.ReplaceAllStringFunc(part, func(match string) string {
+            groups := markdownLinkPattern.FindStringSubmatch(match)
+            label := strings.NewReplacer("**", "", "__", "", "`", "").Replace(groups[1])
+            url := escapeSlackText(groups[2])
+            if label == "" || label == groups[2] {
+                return placeholder("<" + url + ">")
+            }
+            return placeholder("<" + url + "|" + escapeSlackText(label) + ">")
+        })
+        part = markdownAutoLink.ReplaceAllStringFunc(part, func(match string) string {
+            target := markdownAutoLink.FindStringSubmatch(match)[1]
+            if strings.ContainsRune("@#!", rune(target[0])) {
+                // Mentions and channel links are already Slack syntax.
+                return placeholder(match)
+            }
+            return placeholder("<" + escapeSlackText(target) + ">")
+        })
+        part = escapeSlackText(part)
+        part = markdownBoldPattern.ReplaceAllString(part, "\x01$1$2\x01")
+        part = markdownItalicPattern.ReplaceAllString(part, "_${1}_")
+        part = markdownStrikePattern.ReplaceAllString(part, "~$1~")
+        part = strings.ReplaceAll(part, "\x01", "*")
+        for index, value := range saved {
+            part = strings.Replace(part, fmt.Sprintf("\x00%d\x00", index), value, 1)
+        }
+        result.WriteString(part)
+    }
+    return result.String()
+}
+
+// markdownHeader returns the mrkdwn and the plain text of a header. Bold
+// markers are dropped since the whole header is bold.
+func markdownHeader(source string) (string, string) {
+    source = strings.NewReplacer("**", "", "__", "").Replace(source)
+    return "*" + markdownInline(source) + "*", markdownPlainText(source)
+}
+
+// markdownPlainText strips the emphasis markers, code spans and link syntax
+// from inline Markdown, keeping literal characters such as the _ in
+// "config_loader".
+func markdownPlainText(text string) string {
+    text = markdownLinkPattern.ReplaceAllString(text, "$1")
+    text = markdownAutoLink.ReplaceAllString(text, "$1")
+    text = markdownBoldPattern.ReplaceAllString(text, "$1$2")
+    text = markdownStrikePattern.ReplaceAllString(text, "$1")
+    text = markdownItalicPattern.ReplaceAllString(text, "$1")
+    text = markdownUnderscorePattern.ReplaceAllString(text, "$1$2$3")
+    return strings.ReplaceAll(text, "`", "")
+}
+
+// markdownTable renders a GFM table as an aligned code block, since Slack has
+// no table formatting.
+func markdownTable(rows []string) []mrkdwnLine {
+    var cells [][]string
+    var rightAligned []bool
+    for index, row := range rows {
+        row = strings.TrimSpace(row)
+        row = strings.TrimSuffix(strings.TrimPrefix(row, "|"), "|")
+        columns := strings.Split(row, "|")
+        if index == 1 {
+            for _, column := range columns {
+                rightAligned = append(rightAligned, strings.HasSuffix(strings.TrimSpace(column), ":"))
+            }
+            continue
+        }
+        for i, column := range columns {
+            column = strings.NewReplacer("**", "", "__", "", "`", "").Replace(strings.TrimSpace(column))
+            columns[i] = markdownLinkPattern.ReplaceAllString(column, "$1 ($2)")
+        }
+        cells = append(cells, columns)
+    }
+
+    var widths []int
+    for _, row := range cells {
+        for i, cell := range row {
+            if i >= len(widths) {
+                widths = append(widths, 0)
+            }
+            if width := utf8.RuneCountInString(cell); width > widths[i] {
+                widths[i] = width
+            }
+        }
+    }
+
+    lines := []mrkdwnLine{{text: "```", code: true}}
+    for index, row := range cells {
+        var columns []string
+        for i, cell := range row {
+            padding := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+            if i < len(rightAligned) && rightAligned[i] {
+                columns = append(columns, padding+cell)
+            } else {
+                columns = append(columns, cell+padding)
+            }
+        }
+        lines = append(lines, mrkdwnLine{text: escapeSlackText(strings.TrimRight(strings.Join(columns, "  "), " ")), code: true})
+        if index == 0 {
+            var separators []string
+            for _, width := range widths {
+                separators = append(separators, strings.Repeat("-", width))
+            }
+            lines = append(lines, mrkdwnLine{text: strings.Join(separators, "  "), code: true})
+        }
+    }
+    return append(lines, mrkdwnLine{text: "```", code: true})
+}
+
+// convertMarkdown converts CommonMark/GFM to mrkdwn lines: headers become
+// bold, lists get bullets indented by nesting level, tables become code
+// blocks and paragraph lines are joined as Markdown would.
+func convertMarkdown(markdown string) []mrkdwnLine {
+    sourceLines := strings.Split(strings.ReplaceAll(markdown, "\r\n", "\n"), "\n")
+    var lines []mrkdwnLine
+    var listIndents []int
+    inFence := false
+    fence := ""
+    paragraph := false
+
+    for index := 0; index < len(sourceLines); index++ {
+        line := strings.ReplaceAll(sourceLines[index], "\t", "    ")
+        trimmed := strings.TrimSpace(line)
+
+        if inFence {
+            if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+                inFence = false
+                lines = append(lines, mrkdwnLine{text: "```", code: true})
+                continue
+            }
+            lines = append(lines, mrkdwnLine{text: escapeSlackText(line), code: true})
+            continue
+        }
+        if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+            inFence = true
+            fence = trimmed[:3]
+            paragraph = false
+            lines = append(lines, mrkdwnLine{text: "```", code: true})
+            continue
+        }
+
+        if trimmed == "" {
+            paragraph = false
+            listIndents = nil
+            if len(lines) > 0 && lines[len(lines)-1].text != "" {
+                lines = append(lines, mrkdwnLine{})
+            }
+            continue
+        }
+
+        if paragraph && markdownSetextPattern.MatchString(line) && len(lines) > 0 {
+            previous := &lines[len(lines)-1]
+            previous.text, previous.header = markdownHeader(previous.source)
+            paragraph = false
+            continue
+        }
+        if markdownRulePattern.MatchString(line) {
+            paragraph = false
+            listIndents = nil
+            lines = append(lines, mrkdwnLine{text: "──────────", rule: true})
+            continue
+        }
+        if groups := markdownHeaderPattern.FindStringSubmatch(line); groups != nil {
+            paragraph = false
+            listIndents = nil
+            text, header := markdownHeader(groups[2])
+            lines = append(lines, mrkdwnLine{text: text, header: header})
+            continue
+        }
+        if strings.Contains(line, "|") && index+1 < len(sourceLines) && markdownTableSeparator.MatchString(sourceLines[index+1]) && strings.Contains(sourceLines[index+1], "-") {
+            paragraph = false
+            rows := []string{line, sourceLines[index+1]}
+            index += 2
+            for index < len(sourceLines) && strings.Contains(sourceLines[index], "|") && strings.TrimSpace(sourceLines[index]) != "" {
+                rows = append(rows, sourceLines[index])
+                index++
+            }
+            index--
+            lines = append(lines, markdownTable(rows)...)
+            continue
+        }
+        if groups := markdownListPattern.FindStringSubmatch(line); groups != nil {
+            paragraph = false
+            indent := len(groups[1])
+            for len(listIndents) > 0 && indent < listIndents[len(listIndents)-1] {
+                listIndents = listIndents[:len(listIndents)-1]
+            }
+            if len(listIndents) == 0 || indent > listIndents[len(listIndents)-1] {
+                listIndents = append(listIndents, indent)
+            }
+            level := len(listIndents) - 1
+            marker := markdownBullets[level%len(markdownBullets)]
+            if groups[2][0] >= '0' && groups[2][0] <= '9' {
+                marker = groups[2][:len(groups[2])-1] + "."
+            }
+            item := groups[3]
+            if task := markdownTaskPattern.FindStringSubmatch(item); task != nil {
+                marker = "☐"
+                if task[1] != " " {
+                    marker = "☑"
+                }
+                item = task[2]
+            }
+            lines = append(lines, mrkdwnLine{text: strings.Repeat("    ", level) + marker + " " + markdownInline(item)})
+            continue
+        }
+        if strings.HasPrefix(trimmed, ">") {
+            paragraph = false
+            quote := strings.TrimSpace(strings.TrimPrefix(trimmed, ">"))
+            lines = append(lines, mrkdwnLine{text: "> " + markdownInline(quote)})
+            continue
+        }
+
+        hardBreak := strings.HasSuffix(line, "  ") || strings.HasSuffix(trimmed, "\\")
+        source := strings.TrimSuffix(trimmed, "\\")
+        text := markdownInline(source)
+        if len(listIndents) > 0 {
+            // A continuation line of a list item.
+            text = strings.Repeat("    ", len(listIndents)) + text
+        }
+        if paragraph && len(lines) > 0 {
+            lines[len(lines)-1].text += " " + text
+            lines[len(lines)-1].source += " " + source
+        } else {
+            lines = append(lines, mrkdwnLine{text: text, source: source})
+        }
+        paragraph = !hardBreak && len(listIndents) == 0
+    }
+    if inFence {
+        lines = append(lines, mrkdwnLine{text: "```", code: true})
+    }
+    for len(lines) > 0 && lines[len(lines)-1].text == "" {
+        lines = lines[:len(lines)-1]
+    }
+    return lines
+}
+
+// markdownToMrkdwn converts a Markdown document to message text.
+func markdownToMrkdwn(markdown string) string {
+    var texts []string
+    for _, line := range convertMarkdown(markdown) {
+        texts = append(texts, line.text)
+    }
+    return strings.Join(texts, "\n")
+}
+
+// Block Kit limits used when splitting a document into blocks.
+const (
+    maxSectionText = 3000
+    maxHeaderText  = 150
+    maxBlocks      = 50
+)
+
+// markdownToBlocks converts a Markdown document to Block Kit blocks: headers
+// become header blocks, rules become dividers and the text in between is
+// split into sections of at most 3000 characters. Code blocks cut by a split
+// are closed and reopened.
+func markdownToBlocks(markdown string) ([]map[string]interface{}, error) {
+    var blocks []map[string]interface{}
+    var section []string
+    sectionLength := 0
+    flush := func() {
+        text := strings.Trim(strings.Join(section, "\n"), "\n")
+        if text != "" {
+            blocks = append(blocks, map[string]interface{}{
+                "type": "section",
+                "text": map[string]interface{}{"type": "mrkdwn", "text": text},
+            })
+        }
+        section = nil
+        sectionLength = 0
+    }
+
+    // A joined paragraph can be longer than a section on its own, so long
+    // lines are split at spaces first.
+    var lines []mrkdwnLine
+    for _, line := range convertMarkdown(markdown) {
+        for len(line.text) > maxSectionText-8 {
+            cut := strings.LastIndex(line.text[:maxSectionText-8], " ")
+            if cut <= 0 {
+                cut = maxSectionText - 8
+                for !utf8.RuneStart(line.text[cut]) {
+                    cut--
+                }
+            }
+            lines = append(lines, mrkdwnLine{text: line.text[:cut], code: line.code})
+            line.text = strings.TrimLeft(line.text[cut:], " ")
+        }
+        lines = append(lines, line)
+    }
+
+    inCode := false
+    for _, line := range lines {
+        switch {
+        case line.header != "":
+            flush()
+            header := line.header
+            if utf8.RuneCountInString(header) > maxHeaderText {
+                header = string([]rune(header)[:maxHeaderText-1]) + "…"
+            }
+            blocks = append(blocks, map[string]interface{}{
+                "type": "header",
+                "text": map[string]interface{}{"type": "plain_text", "text": header},
+            })
+        case line.rule:
+            flush()
+            blocks = append(blocks, map[string]interface{}{"type": "divider"})
+        default:
+            if line.code && line.text == "```" {
+                inCode = !inCode
+            }
+            if sectionLength+len(line.text)+1 > maxSectionText-4 && len(section) > 0 {
+                if inCode && line.text != "```" {
+                    section = append(section, "```")
+                    flush()
+                    section = []string{"```"}
+                    sectionLength = 4
+                } else {
+                    flush()
+                }
+            }
+            section = append(section, line.text)
+            sectionLength += len(line.text) + 1
+        }
+    }
+    flush()
+
+    if len(blocks) > maxBlocks {
+        return nil, fmt.Errorf("document needs %d blocks, Slack allows %d per message", len(blocks), maxBlocks)
+    }
+    return blocks, nil
+}
+
+// maxAttachments is the number of legacy attachments Slack accepts per
+// message.
+const maxAttachments = 20
+
+// readBlocksFile reads Block Kit JSON from a file, or stdin for "-". It
+// accepts a list of blocks or an object with "blocks" and "attachments", as
+// exported by Block Kit Builder.
+func readBlocksFile(filePath string) ([]map[string]interface{}, []map[string]interface{}, error) {
+    var data []byte
+    var err error
+    if filePath == "-" {
+        data, err = io.ReadAll(os.Stdin)
+    } else {
+        data, err = os.ReadFile(filePath)
+    }
+    if err != nil {
+        return nil, nil, err
+    }
+
+    var blocks []map[string]interface{}
+    if err := json.Unmarshal(data, &blocks); err == nil {
+        return blocks, nil, nil
+    }
+    var message struct {
+        Blocks      []map[string]interface{} `json:"blocks"`
+        Attachments []map[string]interface{} `json:"attachments"`
+    }
+    err = json.Unmarshal(data, &message)
+    if err != nil {
+        return nil, nil, fmt.Errorf("invalid JSON in %s: %v", filePath, err)
+    }
+    if message.Blocks == nil && message.Attachments == nil {
+        return nil, nil, fmt.Errorf("%s has no blocks or attachments", filePath)
+    }
+    return message.Blocks, message.Attachments, nil
+}
+
+// readAttachmentsFile reads legacy attachments, as a list or an object with
+// "attachments".
+func readAttachmentsFile(filePath string) ([]map[string]interface{}, error) {
+    blocks, attachments, err := readBlocksFile(filePath)
+    if err != nil {
+        return nil, err
+    }
+    if attachments == nil {
+        // A bare list was read as blocks.
+        attachments = blocks
+    }
+    return attachments, nil
+}
+
+// blockValidator collects every problem found in a payload so they can be
+// reported together.
+type blockValidator struct {
+    problems []string
+}
+
+func (validator *blockValidator) add(where, format string, args ...interface{}) {
+    validator.problems = append(validator.problems, where+": "+fmt.Sprintf(format, args...))
+}
+
+// textObject checks a Block Kit text object. allowed lists the accepted
+// types, the first being the one named in errors.
+func (validator *blockValidator) textObject(where string, value interface{}, maxLength int, allowed ...string) {
+    object, ok := value.(map[string]interface{})
+    if !ok {
+        validator.add(where, "must be a text object with type and text")
+        return
+    }
+    textType, _ := object["type"].(string)
+    validType := false
+    for _, allowedType := range allowed {
+        if textType == allowedType {
+            validType = true
+        }
+    }
+    if !validType {
+        validator.add(where, "type must be %s, got %q", strings.Join(allowed, " or "), textType)
+    }
+    text, _ := object["text"].(string)
+    if text == "" {
+        validator.add(where, "text is required")
+    } else if utf8.RuneCountInString(text) > maxLength {
+        validator.add(where, "text is %d characters, the limit is %d", utf8.RuneCountInString(text), maxLength)
+    }
+}
+
+func (validator *blockValidator) elements(where string, value interface{}, maxCount int) []map[string]interface{} {
+    list, ok := value.([]interface{})
+    if !ok || len(list) == 0 {
+        validator.add(where, "elements is required")
+        return nil
+    }
+    if len(list) > maxCount {
+        validator.add(where, "has %d elements, the limit is %d", len(list), maxCount)
+    }
+    var elements []map[string]interface{}
+    for index, item := range list {
+        element, ok := item.(map[string]interface{})
+        if !ok {
+            validator.add(fmt.Sprintf("%s element %d", where, index+1), "must be an object")
+            continue
+        }
+        if elementType, _ := element["type"].(string); elementType == "" {
+            validator.add(fmt.Sprintf("%s element %d", where, index+1), "type is required")
+        }
+        elements = append(elements, element)
+    }
+    return elements
+}
+
+// validateBlocks checks the common block types against the Block Kit limits
+// before anything is sent. Unknown block types are reported, other types are
+// passed through for Slack to check.
+func validateBlocks(blocks []map[string]interface{}) error {
+    var validator blockValidator
+    if len(blocks) > maxBlocks {
+        validator.add("blocks", "%d blocks, the limit is %d", len(blocks), maxBlocks)
+    }
+
+    blockIDs := make(map[string]bool)
+    for index, block := range blocks {
+        blockType, _ := block["type"].(string)
+        where := fmt.Sprintf("block %d (%s)", index+1, blockType)
+        if blockID, ok := block["block_id"].(string); ok {
+            if blockIDs[blockID] {
+                validator.add(where, "duplicate block_id %q", blockID)
+            }
+            blockIDs[blockID] = true
+        }
+
+        switch blockType {
+        case "section":
+            fields, hasFields := block["fields"].([]interface{})
+            if block["text"] == nil && !hasFields {
+                validator.add(where, "text or fields is required")
+            }
+            if block["text"] != nil {
+                validator.textObject(where+" text", block["text"], maxSectionText, "mrkdwn", "plain_text")
+            }
+            if len(fields) > 10 {
+                validator.add(where, "has %d fields, the limit is 10", len(fields))
+            }
+            for fieldIndex, field := range fields {
+                validator.textObject(fmt.Sprintf("%s field %d", where, fieldIndex+1), field, 2000, "mrkdwn", "plain_text")
+            }
+        case "header":
+            validator.textObject(where+" text", block["text"], maxHeaderText, "plain_text")
+        case "context":
+            for elementIndex, element := range validator.elements(where, block["elements"], 10) {
+                if element["type"] != "image" {
+                    validator.textObject(fmt.Sprintf("%s element %d", where, elementIndex+1), element, maxSectionText, "mrkdwn", "plain_text")
+                }
+            }
+        case "actions":
+            validator.elements(where, block["elements"], 25)
+        case "image":
+            if block["image_url"] == nil && block["slack_file"] == nil {
+                validator.add(where, "image_url is required")
+            }
+            if altText, _ := block["alt_text"].(string); altText == "" {
+                validator.add(where, "alt_text is required")
+            }
+            if block["title"] != nil {
+                validator.textObject(where+" title", block["title"], 2000, "plain_text")
+            }
+        case "input":
+            validator.textObject(where+" label", block["label"], 2000, "plain_text")
+            if block["element"] == nil {
+                validator.add(where, "element is required")
+            }
+        case "divider", "rich_text", "file", "video", "markdown":
+        case "":
+            validator.add(where, "type is required")
+        default:
+            validator.add(where, "unknown block type")
+        }
+    }
+
+    if len(validator.problems) > 0 {
+        return fmt.Errorf("invalid blocks:\n  %s", strings.Join(validator.problems, "\n  "))
+    }
+    return nil
+}
+
+func validateAttachments(attachments []map[string]interface{}) error {
+    if len(attachments) > maxAttachments {
+        return fmt.Errorf("%d attachments, the limit is %d", len(attachments), maxAttachments)
+    }
+    for index, attachment := range attachments {
+        if blocks, ok := attachment["blocks"].([]interface{}); ok {
+            var attachmentBlocks []map[string]interface{}
+            for _, block := range blocks {
+                if blockMap, ok := block.(map[string]interface{}); ok {
+                    attachmentBlocks = append(attachmentBlocks, blockMap)
+                }
+            }
+            if err := validateBlocks(attachmentBlocks); err != nil {
+                return fmt.Errorf("attachment %d: %v", index+1, err)
+            }
+        }
+    }
+    return nil
+}
+
+// blocksFallbackText is the notification text for a message with blocks or
+// attachments: the first header or line of text found in them.
+func blocksFallbackText(blocks, attachments []map[string]interface{}) string {
+    firstLine := func(value interface{}) string {
+        var text string
+        switch object := value.(type) {
+        case map[string]interface{}:
+            text, _ = object["text"].(string)
+        case string:
+            text = object
+        }
+        for _, line := range strings.Split(text, "\n") {
+            if line = strings.TrimSpace(line); line != "" && line != "```" {
+                return line
+            }
+        }
+        return ""
+    }
+
+    for _, block := range blocks {
+        candidates := []interface{}{block["text"]}
+        if fields, ok := block["fields"].([]interface{}); ok {
+            candidates = append(candidates, fields...)
+        }
+        if elements, ok := block["elements"].([]interface{}); ok && block["type"] == "context" {
+            candidates = append(candidates, elements...)
+        }
+        for _, candidate := range candidates {
+            if line := firstLine(candidate); line != "" {
+                return line
+            }
+        }
+    }
+    for _, attachment := range attachments {
+        for _, key := range []string{"fallback", "pretext", "title", "text"} {
+            if line := firstLine(attachment[key]); line != "" {
+                return line
+            }
+        }
+    }
+    return "Message"
+}
+
+// postRichMessage posts Block Kit blocks and/or attachments with text as the
+// notification fallback.
+func postRichMessage(channelID, text string, blocks, attachments []map[string]interface{}, threadTS string) (string, error) {
+    payload := map[string]interface{}{
+        "channel": channelID,
+        "text":    text,
+    }
+    if blocks != nil {
+        payload["blocks"] = blocks
+    }
+    if attachments != nil {
+        payload["attachments"] = attachments
+    }
+    if threadTS != "" {
+        payload["thread_ts"] = threadTS
+    }
+
+    var response struct {
+        Ts string `json:"ts"`
+    }
+    err := slackAPIPost("chat.postMessage", payload, config.SlackUserToken, &response)
+    return response.Ts, err
+}
+
+func sendRichMessage(text string, blocks, attachments []map[string]interface{}, threadTS string) error {
+    _, err := postRichMessage(currentChannelID(), text, blocks, attachments, threadTS)
+    if err != nil {
+        fmt.Println("Error sending message:", err)
+        return err
+    }
+
+    fmt.Println("Message sent successfully")
+    return nil
+}
+
+// updateRichMessage replaces the text, blocks and attachments of a message.
+// It uses the user token, like postRichMessage, since messages can only be
+// edited with the token that posted them.
+func updateRichMessage(ts, text string, blocks, attachments []map[string]interface{}) error {
+    payload := map[string]interface{}{
+        "channel": currentChannelID(),
+        "ts":      ts,
+        "text":    text,
+    }
+    if blocks != nil {
+        payload["blocks"] = blocks
+    }
+    if attachments != nil {
+        payload["attachments"] = attachments
+    }
+    err := slackAPIPost("chat.update", payload, config.SlackUserToken, nil)
+    if err != nil {
+        fmt.Println("Error updating message:", err)
+        return err
+    }
+
+    fmt.Println("Message updated successfully")
+    return nil
+}
+
+// readRichContent reads --blocks and --attachments, validates them and
+// derives the fallback text when text is empty.
+func readRichContent(text, blocksFile, attachmentsFile string) (string, []map[string]interface{}, []map[string]interface{}, error) {
+    var blocks, attachments []map[string]interface{}
+    var err error
+    if blocksFile != "" {
+        blocks, attachments, err = readBlocksFile(blocksFile)
+        if err != nil {
+            return "", nil, nil, err
+        }
+    }
+    if attachmentsFile != "" {
+        fileAttachments, err := readAttachmentsFile(attachmentsFile)
+        if err != nil {
+            return "", nil, nil, err
+        }
+        attachments = append(attachments, fileAttachments...)
+    }
+
+    if err := validateBlocks(blocks); err != nil {
+        return "", nil, nil, err
+    }
+    if err := validateAttachments(attachments); err != nil {
+        return "", nil, nil, err
+    }
+    if strings.TrimSpace(text) == "" {
+        text = blocksFallbackText(blocks, attachments)
+    }
+    return text, blocks, attachments, nil
+}
+
+// printRichPreview prints a message payload as it would be sent.
+func printRichPreview(text string, blocks, attachments []map[string]interface{}) {
+    payload := map[string]interface{}{"text": text}
+    if blocks != nil {
+        payload["blocks"] = blocks
+    }
+    if attachments != nil {
+        payload["attachments"] = attachments
+    }
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetEscapeHTML(false)
+    encoder.SetIndent("", "  ")
+    encoder.Encode(payload)
+}
+
+var (
+    // mentionProtectedPattern matches code and existing Slack tokens, which
+    // are never rewritten.
+    mentionProtectedPattern = regexp.MustCompile("```[\\s\\S]*?```|`[^`\\n]*`|<[^<>\\n]*>")
+    mentionTokenPattern     = regexp.MustCompile(`(^|[^\p{L}\p{N}_@#/.:&<-])([@#])([\p{L}\p{N}_][\p{L}\p{N}_.\-]*)`)
+)
+
+var specialMentions = map[string]string{
+    "here":     "<!here>",
+    "channel":  "<!channel>",
+    "everyone": "<!everyone>",
+}
+
+// mentionResolver turns @name and #channel into Slack mention syntax. Names
+// are looked up in the cache first; the user list, the user groups and the
+// channel list are each fetched at most once per message, and never when
+// offline (for --preview).
+type mentionResolver struct {
+    offline           bool
+    channelsRefreshed bool
+    groups            map[string]string
+    warnings          []string
+}
+
+func (resolver *mentionResolver) warn(format string, args ...interface{}) {
+    resolver.warnings = append(resolver.warnings, fmt.Sprintf(format, args...))
+}
+
+// userIDs returns the users called name by handle or, failing that, by
+// display name or real name. The user list is only fetched when the cache
+// has no match.
+func (resolver *mentionResolver) userIDs(name string) []string {
+    if ids := cachedUserIDs(name); len(ids) > 0 || resolver.offline {
+        return ids
+    }
+    users, err := userList()
+    if err != nil {
+        resolver.warn("could not fetch users: %v", err)
+        return nil
+    }
+    var ids []string
+    for _, user := range matchUsers(name, users) {
+        ids = append(ids, user.ID)
+    }
+    return ids
+}
+
+// userLabel names a user in a warning.
+func (resolver *mentionResolver) userLabel(id string) string {
+    for _, user := range fetchedUsers {
+        if user.ID == id {
+            return fmt.Sprintf("%s (@%s, %s)", user.displayName(), user.Name, id)
+        }
+    }
+    if handle := config.UserHandles[id]; handle != "" {
+        return fmt.Sprintf("%s (@%s, %s)", config.UserCache[id], handle, id)
+    }
+    if name := config.UserCache[id]; name != "" {
+        return fmt.Sprintf("%s (%s)", name, id)
+    }
+    return id
+}
+
+func (resolver *mentionResolver) groupID(handle string) string {
+    if resolver.groups == nil && !resolver.offline {
+        resolver.groups = make(map[string]string)
+        var response struct {
+            Usergroups []struct {
+                ID     string `json:"id"`
+                Handle string `json:"handle"`
+            } `json:"usergroups"`
+        }
+        err := slackAPIGet("usergroups.list", nil, config.SlackUserToken, &response)
+        if err != nil {
+            resolver.warn("could not fetch user groups: %v", err)
+        }
+        for _, group := range response.Usergroups {
+            resolver.groups[strings.ToLower(group.Handle)] = group.ID
+        }
+    }
+    return resolver.groups[strings.ToLower(handle)]
+}
+
+// userSuggestions lists the closest user and group names for a warning.
+func (resolver *mentionResolver) userSuggestions(name string) string {
+    candidates := make(map[string]string)
+    for id, cachedName := range config.UserCache {
+        candidates[id] = cachedName
+    }
+    for id, handle := range config.UserHandles {
+        candidates[id] = handle
+    }
+    for handle, id := range resolver.groups {
+        candidates[id] = handle
+    }
+    var names []string
+    for _, match := range rankChannels(name, candidates) {
+        if len(names) == 5 {
+            break
+        }
+        names = append(names, "@"+match.Name)
+    }
+    return strings.Join(names, ", ")
+}
+
+func (resolver *mentionResolver) resolveUser(name string) (string, bool) {
+    if special, exists := specialMentions[strings.ToLower(name)]; exists {
+        return special, true
+    }
+
+    ids := resolver.userIDs(name)
+    if len(ids) == 1 {
+        return "<@" + ids[0] + ">", true
+    }
+    if len(ids) > 1 {
+        var matches []string
+        for _, id := range ids {
+            matches = append(matches, resolver.userLabel(id))
+        }
+        resolver.warn("@%s matches several users: %s; left as text", name, strings.Join(matches, ", "))
+        return "", false
+    }
+    if groupID := resolver.groupID(name); groupID != "" {
+        return "<!subteam^" + groupID + ">", true
+    }
+
+    if suggestions := resolver.userSuggestions(name); suggestions != "" {
+        resolver.warn("@%s is not a known user or group, did you mean: %s", name, suggestions)
+    } else {
+        resolver.warn("@%s is not a known user or group", name)
+    }
+    return "", false
+}
+
+func (resolver *mentionResolver) resolveChannel(name string) (string, bool) {
+    channelCache := config.ChannelCache
+    var err error
+    if !resolver.offline {
+        channelCache, err = getChannelList()
+        if err != nil {
+            resolver.warn("could not fetch channels: %v", err)
+            return "", false
+        }
+    }
+    find := func(channels map[string]string) []string {
+        var ids []string
+        for id, cachedName := range channels {
+            if strings.EqualFold(cachedName, name) {
+                ids = append(ids, id)
+            }
+        }
+        return ids
+    }
+    ids := find(channelCache)
+    if len(ids) == 0 && !resolver.channelsRefreshed && !resolver.offline {
+        resolver.channelsRefreshed = true
+        config.ChannelCache = nil
+        channelCache, err = getChannelList()
+        if err != nil {
+            resolver.warn("could not fetch channels: %v", err)
+            return "", false
+        }
+        ids = find(channelCache)
+    }
+
+    if len(ids) == 1 {
+        return "<#" + ids[0] + ">", true
+    }
+    if len(ids) > 1 {
+        resolver.warn("#%s matches several channels: %s; left as text", name, strings.Join(ids, ", "))
+        return "", false
+    }
+    channels := make(map[string]string)
+    for id, channelName := range channelCache {
+        if !strings.HasPrefix(channelName, "@") {
+            channels[id] = channelName
+        }
+    }
+    var suggestions []string
+    for _, match := range rankChannels(name, channels) {
+        if len(suggestions) == 5 {
+            break
+        }
+        suggestions = append(suggestions, "#"+match.Name)
+    }
+    if len(suggestions) > 0 {
+        resolver.warn("#%s is not a known channel, did you mean: %s", name, strings.Join(suggestions, ", "))
+    } else {
+        resolver.warn("#%s is not a known channel", name)
+    }
+    return "", false
+}
+
+func (resolver *mentionResolver) resolveSegment(text string) string {
+    return mentionTokenPattern.ReplaceAllStringFunc(text, func(match string) string {
+        groups := mentionTokenPattern.FindStringSubmatch(match)
+        prefix, sigil, name := groups[1], groups[2], groups[3]
+        // Sentence punctuation after a name is not part of it.
+        trimmed := strings.TrimRight(name, ".-")
+        suffix := name[len(trimmed):]
+
+        var resolved string
+        var ok bool
+        if sigil == "@" {
+            resolved, ok = resolver.resolveUser(trimmed)
+        } else {
+            // "#123" is usually an issue number, not a channel.
+            if strings.Trim(trimmed, "0123456789") == "" {
+                return match
+            }
+            resolved, ok = resolver.resolveChannel(trimmed)
+        }
+        if !ok {
+            return match
+        }
+        return prefix + resolved + suffix
+    })
+}
+
+// resolveMentions rewrites @name, @here/@channel/@everyone, @group and
+// #channel in text to Slack's <@U..>, <!here>, <!subteam^..> and <#C..>
+// tokens. Code and existing <...> tokens are left alone. Names that are
+// unknown or ambiguous stay as text and are reported with suggestions. When
+// offline only the cache is used, so a preview makes no API calls.
+func resolveMentions(text string, offline bool) (string, []string) {
+    resolver := mentionResolver{offline: offline}
+    var result strings.Builder
+    last := 0
+    for _, location := range mentionProtectedPattern.FindAllStringIndex(text, -1) {
+        result.WriteString(resolver.resolveSegment(text[last:location[0]]))
+        result.WriteString(text[location[0]:location[1]])
+        last = location[1]
+    }
+    result.WriteString(resolver.resolveSegment(text[last:]))
+    return result.String(), resolver.warnings
+}
+
+func main() {
+    checkAndLoadConfig()
+
+    fmt.Printf("Slack CLI (build time: %s)\n", buildTime)
+
+    var rootCmd = &cobra.Command{
+        Use: "slack",
+        PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+            if flushesOutbox(cmd) {
+                if err := flushOutbox(true); err != nil {
+                    fmt.Println("Error flushing outbox:", err)
+                }
+            }
+            channel, _ := cmd.Flags().GetString("channel")
+            if channel == "" {
+                return nil
+            }
+            channelID, err := resolveChannel(channel)
+            if err != nil {
+                return err
+            }
+            targetChannelID = channelID
+            if !recordRecentChannel(channelID) {
+                return nil
+            }
+            return saveConfig()
+        },
+    }
+    rootCmd.PersistentFlags().String("channel", "", "Target channel for this command (ID, #name or @user); the saved default is not changed")
+
+    var sendCmd = &cobra.Command{
+        Use:   "send [message|-]",
+        Short: "Send a message to Slack (\"-\" reads stdin, no message opens $EDITOR)",
+        Args:  cobra.MaximumNArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            threadTS, _ := cmd.Flags().GetString("ts")
+            fileName, _ := cmd.Flags().GetString("file")
+            templateName, _ := cmd.Flags().GetString("template")
+            blocksFile, _ := cmd.Flags().GetString("blocks")
+            attachmentsFile, _ := cmd.Flags().GetString("attachments")
+            rich := blocksFile != "" || attachmentsFile != ""
+            var message string
+            var err error
+            if templateName != "" {
+                if len(args) > 0 || fileName != "" {
+                    fmt.Println("Error: --template can't be combined with a message or --file")
+                    return
+                }
+                vars, _ := cmd.Flags().GetStringArray("var")
+                varsFile, _ := cmd.Flags().GetString("vars")
+                variables, err := templateVariables(vars, varsFile)
+                if err != nil {
+                    fmt.Println("Error:", err)
+                    return
+                }
+                preview, _ := cmd.Flags().GetBool("preview")
+                message, err = renderTemplate(templateName, variables, preview)
+                if err != nil {
+                    fmt.Println("Error rendering template:", err)
+                    return
+                }
+            } else if !rich || len(args) > 0 || fileName != "" {
+                // With --blocks or --attachments the message is only the
+                // optional fallback text.
+                message, err = readMessageText(args, fileName, threadTS)
+                if err != nil {
+                    fmt.Println("Error:", err)
+                    return
+                }
+            }
+            if strings.TrimSpace(message) == "" && !rich {
+                fmt.Println("Error: message is required")
+                return
+            }
+            preview, _ := cmd.Flags().GetBool("preview")
+            if noResolve, _ := cmd.Flags().GetBool("no-resolve"); !noResolve && message != "" {
+                var warnings []string
+                message, warnings = resolveMentions(message, preview)
+                for _, warning := range warnings {
+                    fmt.Println("Warning:", warning)
+                }
+            }
+            markdown, _ := cmd.Flags().GetBool("markdown")
+            sections, _ := cmd.Flags().GetBool("sections")
+            if sections && !markdown {
+                fmt.Println("Error: --sections requires --markdown")
+                return
+            }
+            if sections && rich {
+                fmt.Println("Error: --sections can't be combined with --blocks or --attachments")
+                return
+            }
+            if markdown && !sections {
+                message = markdownToMrkdwn(message)
+            }
+            if sections || rich {
+                var blocks, attachments []map[string]interface{}
+                if sections {
+                    blocks, err = markdownToBlocks(message)
+                    message = blocksFallbackText(blocks, nil)
+                } else {
+                    message, blocks, attachments, err = readRichContent(message, blocksFile, attachmentsFile)
+                }
+                if err != nil {
+                    fmt.Println("Error:", err)
+                    return
+                }
+                if preview {
+                    printRichPreview(message, blocks, attachments)
+                    return
+                }
+                for _, flag := range []string{"at", "in", "queue", "dedupe-key"} {
+                    if cmd.Flags().Changed(flag) {
+                        fmt.Printf("Error: blocks and attachments can't be combined with --%s\n", flag)
+                        return
+                    }
+                }
+                sendRichMessage(message, blocks, attachments, threadTS)
+                return
+            }
+            if preview {
+                fmt.Println(message)
+                return
+            }
+            at, _ := cmd.Flags().GetString("at")
+            in, _ := cmd.Flags().GetString("in")
+            if at != "" || in != "" {
+                for _, flag := range []string{"queue", "dedupe-key"} {
+                    if cmd.Flags().Changed(flag) {
+                        fmt.Printf("Error: --at and --in can't be combined with --%s\n", flag)
+                        return
+                    }
+                }
+                postAt, err := parseScheduleTime(at, in, time.Now())
+                if err != nil {
+                    fmt.Println("Error:", err)
+                    return
+                }
+                sendScheduled(message, threadTS, postAt)
+                return
+            }
+            dedupeKey, _ := cmd.Flags().GetString("dedupe-key")
+            if dedupeKey != "" && cmd.Flags().Changed("queue") {
+                fmt.Println("Error: --dedupe-key can't be combined with --queue")
+                return
+            }
+            if dedupeKey != "" {
+                window, _ := cmd.Flags().GetDuration("window")
+                mode, _ := cmd.Flags().GetString("dedupe-mode")
+                sendDeduplicated(message, threadTS, dedupeKey, window, mode)
+                return
+            }
+            queue, _ := cmd.Flags().GetBool("queue")
+            if queue {
+                if err := sendQueued(message, threadTS); err != nil {
+                    fmt.Println("Error queueing message:", err)
+                }
+                return
+            }
+            sendMessage(message, threadTS)
+        },
+    }
+    sendCmd.Flags().String("ts", "", "Thread timestamp")
+    sendCmd.Flags().String("file", "", "Read the message from a file")
+    sendCmd.Flags().String("template", "", "Render a named template from the config as the message")
+    sendCmd.Flags().StringArray("var", nil, "Template variable as key=value (repeatable)")
+    sendCmd.Flags().String("vars", "", "JSON file with template variables")
+    sendCmd.Flags().Bool("preview", false, "Print the message instead of sending it")
+    sendCmd.Flags().Bool("no-resolve", false, "Send @name and #channel as plain text instead of mentions")
+    sendCmd.Flags().Bool("markdown", false, "Convert the message from Markdown (GFM) to Slack mrkdwn")
+    sendCmd.Flags().Bool("sections", false, "With --markdown, post the document as Block Kit header and section blocks")
+    sendCmd.Flags().String("blocks", "", "Send Block Kit blocks from a JSON file (\"-\" reads stdin); the message becomes the fallback text")
+    sendCmd.Flags().String("attachments", "", "Send legacy attachments from a JSON file")
+    sendCmd.Flags().String("dedupe-key", "", "Update the earlier message with this key instead of posting again within --window")
+    sendCmd.Flags().Duration("window", 10*time.Minute, "Time window for --dedupe-key")
+    sendCmd.Flags().String("dedupe-mode", "update", "How repeats are reported: update (counter on the original) or thread (reply)")
+    sendCmd.Flags().String("at", "", "Schedule the message for \"YYYY-MM-DD HH:MM\" or HH:MM in the configured time zone")
+    sendCmd.Flags().String("in", "", "Schedule the message after a duration such as 30m, 2h or 1d")
+    sendCmd.Flags().Bool("queue", false, "Keep the message in the outbox and retry later if delivery fails")
+
+    var showCmd = &cobra.Command{
+        Use:   "show [limit]",
+        Short: "Get messages from Slack",
+        Args:  cobra.MaximumNArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            var limit int
+            if len(args) > 0 {
+                parsedLimit, err := strconv.Atoi(args[0])
+                if err != nil {
+                    fmt.Println("Invalid limit value, using default value.")
+                    limit = config.DefaultShowLimit
+                } else {
+                    limit = parsedLimit
+                }
+            } else {
+                limit, _ = cmd.Flags().GetInt("limit")
+            }
+            date, _ := cmd.Flags().GetString("date")
+            search, _ := cmd.Flags().GetString("search")
+            filter, _ := cmd.Flags().GetString("filter")
+            showFilesOnly, _ := cmd.Flags().GetBool("files")
+            before, _ := cmd.Flags().GetInt("before-context")
+            after, _ := cmd.Flags().GetInt("after-context")
+            if cmd.Flags().Changed("context") {
+                context, _ := cmd.Flags().GetInt("context")
+                if !cmd.Flags().Changed("before-context") {
+                    before = context
+                }
+                if !cmd.Flags().Changed("after-context") {
+                    after = context
+                }
+            }
+            fetchMessages(limit, date, search, filter, showFilesOnly, before, after)
+        },
+    }
+    showCmd.Flags().String("date", "", "Date or date range for filtering messages (YYYY-MM-DD or YYYY-MM-DD:YYYY-MM-DD)")
+    showCmd.Flags().String("search", "", "Keyword to search in messages")
+    showCmd.Flags().String("filter", "", "Keyword to filter messages")
+    showCmd.Flags().Int("limit", config.DefaultShowLimit, "Limit the number of messages to retrieve")
+    showCmd.Flags().Bool("files", false, "Show only messages with files")
+    showCmd.Flags().IntP("after-context", "A", 0, "Show N messages after each --filter match")
+    showCmd.Flags().IntP("before-context", "B", 0, "Show N messages before each --filter match")
+    showCmd.Flags().IntP("context", "C", 0, "Show N messages before and after each --filter match")
+
+    var uploadCmd = &cobra.Command{
+        Use:   "upload [filePath]",
+        Short: "Upload a file to Slack",
+        Run: func(cmd *cobra.Command, args []string) {
+            if len(args) < 1 {
+                fmt.Println("Error: file path is required")
+                return
+            }
+            uploadFile(args[0])
+        },
+    }
+
+    var downloadCmd = &cobra.Command{
+        Use:   "download [fileURL]",
+        Short: "Download a file from Slack",
+        Run: func(cmd *cobra.Command, args []string) {
+            if len(args) < 1 {
+                fmt.Println("Error: file URL is required")
+                return
+            }
+            getFile(args[0])
+        },
+    }
+
+    var emojiCmd = &cobra.Command{
+        Use:   "emoji [ts] [emoji]",
+        Short: "Add or remove a reaction to a Slack message",
+        Run: func(cmd *cobra.Command, args []string) {
+            ts := ""
+            emoji := ""
+            if len(args) > 0 {
+                ts = args[0]
+            }
+            if len(args) > 1 {
+                emoji = args[1]
+            }
+            add, _ := cmd.Flags().GetString("add")
+            del, _ := cmd.Flags().GetString("del")
+            handleEmoji(ts, emoji, add, del)
+        },
+    }
+    emojiCmd.Flags().String("add", "", "Add a reaction to the message")
+    emojiCmd.Flags().String("del", "", "Remove a reaction from the message")
+
+    var editCmd = &cobra.Command{
+        Use:   "edit [ts] [message]",
+        Short: "Update a Slack message",
+        Run: func(cmd *cobra.Command, args []string) {
+            blocksFile, _ := cmd.Flags().GetString("blocks")
+            attachmentsFile, _ := cmd.Flags().GetString("attachments")
+            if blocksFile != "" || attachmentsFile != "" {
+                if len(args) < 1 {
+                    fmt.Println("Error: ts (timestamp) is required")
+                    return
+                }
+                text := ""
+                if len(args) > 1 {
+                    text = args[1]
+                }
+                text, blocks, attachments, err := readRichContent(text, blocksFile, attachmentsFile)
+                if err != nil {
+                    fmt.Println("Error:", err)
+                    return
+                }
+                if preview, _ := cmd.Flags().GetBool("preview"); preview {
+                    printRichPreview(text, blocks, attachments)
+                    return
+                }
+                updateRichMessage(args[0], text, blocks, attachments)
+                return
+            }
+            if len(args) < 2 {
+                fmt.Println("Error: ts (timestamp) and message are required")
+                return
+            }
+            updateMessage(args[0], args[1])
+        },
+    }
+    editCmd.Flags().String("blocks", "", "Replace the blocks with Block Kit JSON from a file (\"-\" reads stdin)")
+    editCmd.Flags().String("attachments", "", "Replace the attachments with JSON from a file")
+    editCmd.Flags().Bool("preview", false, "Print the payload instead of updating the message")
+
+    var deleteCmd = &cobra.Command{
+        Use:   "delete [ts]",
+        Short: "Delete a Slack message",
+        Run: func(cmd *cobra.Command, args []string) {
+            if len(args) < 1 {
+                fmt.Println("Error: ts (timestamp) is required")
+                return
+            }
+            deleteMessage(args[0])
+        },
+    }
+
+    var channelsCmd = &cobra.Command{
+        Use:   "channels [--current [name]]",
+        Short: "List all Slack channels and cache them",
+        Args:  cobra.MaximumNArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            setCurrent, _ := cmd.Flags().GetBool("current")
+            showRecent, _ := cmd.Flags().GetBool("recent")
+            if len(args) > 0 && !setCurrent {
+                fmt.Println("Error: a channel name is only accepted with --current")
+                return
+            }
+            currentChannel := ""
+            if len(args) > 0 {
+                currentChannel = args[0]
+            }
+
+            if showRecent {
+                fmt.Println("Recently used channels:")
+                for _, id := range config.RecentChannels {
+                    fmt.Printf("%s: %s\n", id, channelDisplayName(id))
+                }
+            } else if setCurrent && currentChannel == "" {
+                channelCache, err := getChannelList()
+                if err != nil {
+                    fmt.Println("Error fetching channel list:", err)
+                    return
+                }
+                channelID, err := pickChannel(channelCache)
+                if err != nil {
+                    fmt.Println("Error selecting channel:", err)
+                    return
+                }
+                if channelID == "" {
+                    fmt.Printf("Current default channel: %s (%s)\n", channelDisplayName(config.ChannelID), config.ChannelID)
+                    return
+                }
+                err = setDefaultChannel(channelID)
+                if err != nil {
+                    fmt.Println("Error setting default channel:", err)
+                }
+            } else if !setCurrent {
+                typesValue, _ := cmd.Flags().GetString("types")
+                archived, _ := cmd.Flags().GetBool("archived")
+                memberOnly, _ := cmd.Flags().GetBool("member-only")
+                sortBy, _ := cmd.Flags().GetString("sort")
+                types, err := parseConversationTypes(typesValue)
+                if err != nil {
+                    fmt.Println("Error:", err)
+                    return
+                }
+                err = printChannelTable(types, archived, memberOnly, sortBy)
+                if err != nil {
+                    fmt.Println("Error fetching channel list:", err)
+                    return
+                }
+        
+                fmt.Printf("Current default channel: %s (%s)\n", config.ChannelCache[config.ChannelID], config.ChannelID)
+            } else {
+                var channelID string
+                for id, name := range config.ChannelCache {
+                    if name == currentChannel {
+                        channelID = id
+                        break
+                    }
+                }
+                if channelID == "" {
+                    matches := rankChannels(currentChannel, config.ChannelCache)
+                    fmt.Printf("Channel %s not found\n", currentChannel)
+                    if len(matches) > 0 {
+                        fmt.Println("Did you mean:")
+                        for i := 0; i < len(matches) && i < 5; i++ {
+                            fmt.Printf("  %s (%s)\n", matches[i].Name, matches[i].ID)
+                        }
+                    }
+                    return
+                }
+                err := setDefaultChannel(channelID)
+                if err != nil {
+                    fmt.Println("Error setting default channel:", err)
+                }
+            }
+        },
+    }
+    
+    channelsCmd.Flags().Bool("current", false, "Set the default channel to the named channel, or pick one interactively when no name is given")
+    channelsCmd.Flags().Bool("recent", false, "List recently used channels")
+
+    var channelsCreateCmd = &cobra.Command{
+        Use:   "create [name]",
+        Short: "Create a channel",
+        Args:  cobra.ExactArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            private, _ := cmd.Flags().GetBool("private")
+            if err := createChannel(args[0], private); err != nil {
+                fmt.Println("Error creating channel:", err)
+            }
+        },
+    }
+    channelsCreateCmd.Flags().Bool("private", false, "Create a private channel")
+
+    var channelsArchiveCmd = &cobra.Command{
+        Use:   "archive <channel>",
+        Short: "Archive a channel (the default channel is never archived implicitly)",
+        Args:  cobra.MaximumNArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            // Archiving is hard to undo, so the channel must be named here
+            // or with --channel rather than taken from the saved default.
+            if len(args) == 0 && targetChannelID == "" {
+                fmt.Println("Error archiving channel: name the channel to archive, e.g. channels archive \"#old-project\"")
+                return
+            }
+            channelID, err := adminChannelTarget(args)
+            if err == nil {
+                err = archiveChannel(channelID)
+            }
+            if err != nil {
+                fmt.Println("Error archiving channel:", err)
+            }
+        },
+    }
+
+    var channelsUnarchiveCmd = &cobra.Command{
+        Use:   "unarchive [channel]",
+        Short: "Unarchive a channel",
+        Args:  cobra.ExactArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            if err := unarchiveChannel(args[0]); err != nil {
+                fmt.Println("Error unarchiving channel:", err)
+            }
+        },
+    }
+
+    var channelsRenameCmd = &cobra.Command{
+        Use:   "rename [new-name]",
+        Short: "Rename the current channel",
+        Args:  cobra.ExactArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            channelID, err := adminChannelTarget(nil)
+            if err == nil {
+                err = renameChannel(channelID, args[0])
+            }
+            if err != nil {
+                fmt.Println("Error renaming channel:", err)
+            }
+        },
+    }
+
+    var channelsJoinCmd = &cobra.Command{
+        Use:   "join [channel]",
+        Short: "Join a channel (default: current channel)",
+        Args:  cobra.MaximumNArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            channelID, err := adminChannelTarget(args)
+            if err == nil {
+                err = joinChannel(channelID)
+            }
+            if err != nil {
+                fmt.Println("Error joining channel:", err)
+            }
+        },
+    }
+
+    var channelsLeaveCmd = &cobra.Command{
+        Use:   "leave [channel]",
+        Short: "Leave a channel (default: current channel)",
+        Args:  cobra.MaximumNArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            channelID, err := adminChannelTarget(args)
+            if err == nil {
+                err = leaveChannel(channelID)
+            }
+            if err != nil {
+                fmt.Println("Error leaving channel:", err)
+            }
+        },
+    }
+
+    var channelsInviteCmd = &cobra.Command{
+        Use:   "invite [@user...]",
+        Short: "Invite users to the current channel",
+        Args:  cobra.MinimumNArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            channelID, err := adminChannelTarget(nil)
+            if err == nil {
+                err = inviteToChannel(channelID, args)
+            }
+            if err != nil {
+                fmt.Println("Error inviting users:", err)
+            }
+        },
+    }
+
+    var channelsKickCmd = &cobra.Command{
+        Use:   "kick [@user...]",
+        Short: "Remove users from the current channel",
+        Args:  cobra.MinimumNArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            channelID, err := adminChannelTarget(nil)
+            if err == nil {
+                err = kickFromChannel(channelID, args)
+            }
+            if err != nil {
+                fmt.Println("Error removing users:", err)
+            }
+        },
+    }
+
+    var channelsTopicCmd = &cobra.Command{
+        Use:   "topic [text]",
+        Short: "Set the topic of the current channel",
+        Args:  cobra.ExactArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            channelID, err := adminChannelTarget(nil)
+            if err == nil {
+                err = setChannelTopic(channelID, args[0])
+            }
+            if err != nil {
+                fmt.Println("Error setting topic:", err)
+            }
+        },
+    }
+
+    var channelsPurposeCmd = &cobra.Command{
+        Use:   "purpose [text]",
+        Short: "Set the purpose of the current channel",
+        Args:  cobra.ExactArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            channelID, err := adminChannelTarget(nil)
+            if err == nil {
+                err = setChannelPurpose(channelID, args[0])
+            }
+            if err != nil {
+                fmt.Println("Error setting purpose:", err)
+            }
+        },
+    }
+
+    var channelsSyncCmd = &cobra.Command{
+        Use:   "sync [members-file]",
+        Short: "Sync channel members with a YAML or JSON file",
+        Args:  cobra.ExactArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            apply, _ := cmd.Flags().GetBool("apply")
+            dryRun, _ := cmd.Flags().GetBool("dry-run")
+            if cmd.Flags().Changed("dry-run") && dryRun {
+                apply = false
+            }
+            if err := syncChannelMembers(args[0], apply); err != nil {
+                fmt.Println("Error syncing channel members:", err)
+            }
+        },
+    }
+    channelsSyncCmd.Flags().Bool("apply", false, "Invite and remove members according to the plan")
+    channelsSyncCmd.Flags().Bool("dry-run", true, "Only print the plan (default unless --apply is given)")
+
+    channelsCmd.AddCommand(channelsCreateCmd)
+    channelsCmd.AddCommand(channelsArchiveCmd)
+    channelsCmd.AddCommand(channelsUnarchiveCmd)
+    channelsCmd.AddCommand(channelsRenameCmd)
+    channelsCmd.AddCommand(channelsJoinCmd)
+    channelsCmd.AddCommand(channelsLeaveCmd)
+    channelsCmd.AddCommand(channelsInviteCmd)
+    channelsCmd.AddCommand(channelsKickCmd)
+    channelsCmd.AddCommand(channelsTopicCmd)
+    channelsCmd.AddCommand(channelsPurposeCmd)
+    channelsCmd.AddCommand(channelsSyncCmd)
+    channelsCmd.Flags().String("types", "public,private,im,mpim", "Comma-separated channel types to list (public, private, im, mpim)")
+    channelsCmd.Flags().Bool("archived", false, "Include archived channels")
+    channelsCmd.Flags().Bool("member-only", false, "Only list channels you are a member of")
+    channelsCmd.Flags().String("sort", "name", "Sort by name, members or created")
+    
+    
+    
+
+    var searchCmd = &cobra.Command{
+        Use:   "search [query]",
+        Short: "Search messages or files across the workspace",
+        Args:  cobra.MinimumNArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            limit, _ := cmd.Flags().GetInt("limit")
+            sortBy, _ := cmd.Flags().GetString("sort")
+            asc, _ := cmd.Flags().GetBool("asc")
+            files, _ := cmd.Flags().GetBool("files")
+            err := searchWorkspace(strings.Join(args, " "), limit, sortBy, asc, files)
+            if err != nil {
+                fmt.Println("Error searching:", err)
+            }
+        },
+    }
+    searchCmd.Flags().Int("limit", config.DefaultShowLimit, "Maximum number of results to show (0 for all)")
+    searchCmd.Flags().String("sort", "score", "Sort results by score or time")
+    searchCmd.Flags().Bool("asc", false, "Sort in ascending order")
+    searchCmd.Flags().Bool("files", false, "Search files instead of messages")
+
+    var usersCmd = &cobra.Command{
+        Use:   "users",
+        Short: "Look up people in the workspace",
+    }
+
+    var usersListCmd = &cobra.Command{
+        Use:   "list",
+        Short: "List all users and refresh the user cache",
+        Args:  cobra.NoArgs,
+        Run: func(cmd *cobra.Command, args []string) {
+            activeOnly, _ := cmd.Flags().GetBool("active")
+            withPresence, _ := cmd.Flags().GetBool("presence")
+            if err := listUsers(activeOnly, withPresence); err != nil {
+                fmt.Println("Error listing users:", err)
+            }
+        },
+    }
+    usersListCmd.Flags().Bool("active", false, "Hide bots and deactivated accounts")
+    usersListCmd.Flags().Bool("presence", false, "Look up presence for every user (one API call per user)")
+
+    var usersSearchCmd = &cobra.Command{
+        Use:   "search [text]",
+        Short: "Search users by name, title or email",
+        Args:  cobra.ExactArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            if err := searchUsers(args[0]); err != nil {
+                fmt.Println("Error searching users:", err)
+            }
+        },
+    }
+
+    var usersInfoCmd = &cobra.Command{
+        Use:   "info [@name|user ID]",
+        Short: "Show details about a user",
+        Args:  cobra.ExactArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            if err := showUserInfo(args[0]); err != nil {
+                fmt.Println("Error getting user info:", err)
+            }
+        },
+    }
+
+    usersCmd.AddCommand(usersListCmd)
+    usersCmd.AddCommand(usersSearchCmd)
+    usersCmd.AddCommand(usersInfoCmd)
 
-    if !response.OK {
-        fmt.Println("Error in response. Response body:", string(body))
-        return fmt.Errorf("Failed to delete message: %s", response.Error)
+    var statusCmd = &cobra.Command{
+        Use:   "status",
+        Short: "Show, set or clear your Slack status",
+        Args:  cobra.NoArgs,
+        Run: func(cmd *cobra.Command, args []string) {
+            if err := showStatus(); err != nil {
+                fmt.Println("Error getting status:", err)
+            }
+        },
     }
 
-    fmt.Println("Message deleted successfully")
-    return nil
-}
+    var statusSetCmd = &cobra.Command{
+        Use:   "set [\":emoji: text\"|preset]",
+        Short: "Set your status, or apply a preset from the config file",
+        Args:  cobra.ExactArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            emoji, text := splitStatusText(args[0])
+            duration := ""
+            if preset, exists := statusPresets()[args[0]]; exists {
+                emoji, text, duration = preset.Emoji, preset.Text, preset.Duration
+            }
+            if cmd.Flags().Changed("emoji") {
+                emoji, _ = cmd.Flags().GetString("emoji")
+            }
 
+            var expiration time.Time
+            until, _ := cmd.Flags().GetString("until")
+            if cmd.Flags().Changed("for") {
+                duration, _ = cmd.Flags().GetString("for")
+            }
+            if until != "" {
+                parsed, err := parseUntil(until)
+                if err != nil {
+                    fmt.Println("Error:", err)
+                    return
+                }
+                expiration = parsed
+            } else if duration != "" {
+                parsed, err := parseDuration(duration)
+                if err != nil {
+                    fmt.Println("Error:", err)
+                    return
+                }
+                expiration = time.Now().Add(parsed)
+            }
 
-func handleError(body io.Reader) error {
-    var response struct {
-        OK    bool   `json:"ok"`
-        Error string `json:"error"`
-    }
-    if err := json.NewDecoder(body).Decode(&response); err != nil {
-        return err
+            if err := setStatus(emoji, text, expiration); err != nil {
+                fmt.Println("Error setting status:", err)
+            }
+        },
     }
-    if !response.OK {
-        return fmt.Errorf(response.Error)
+    statusSetCmd.Flags().String("emoji", "", "Status emoji, e.g. :palm_tree:")
+    statusSetCmd.Flags().String("until", "", "Clear the status after this date (YYYY-MM-DD or \"YYYY-MM-DD HH:MM\")")
+    statusSetCmd.Flags().String("for", "", "Clear the status after this duration (e.g. 45m, 2h, 3d)")
+
+    var statusClearCmd = &cobra.Command{
+        Use:   "clear",
+        Short: "Clear your status",
+        Args:  cobra.NoArgs,
+        Run: func(cmd *cobra.Command, args []string) {
+            if err := clearStatus(); err != nil {
+                fmt.Println("Error clearing status:", err)
+            }
+        },
     }
-    return nil
-}
 
-func handleEmoji(ts, emoji, add, del string) error {
-    if add != "" {
-        return addReaction(ts, add)
-    } else if del != "" {
-        return removeReaction(ts, del)
-    } else {
-        if emoji == "" {
-            return listEmoji()
-        } else {
-            return addReaction(ts, emoji)
-        }
+    var statusPresetsCmd = &cobra.Command{
+        Use:   "presets",
+        Short: "List status presets",
+        Args:  cobra.NoArgs,
+        Run: func(cmd *cobra.Command, args []string) {
+            presets := statusPresets()
+            var names []string
+            for name := range presets {
+                names = append(names, name)
+            }
+            sort.Strings(names)
+            for _, name := range names {
+                preset := presets[name]
+                duration := preset.Duration
+                if duration == "" {
+                    duration = "no expiry"
+                }
+                fmt.Printf("%s: %s %s (%s)\n", name, preset.Emoji, preset.Text, duration)
+            }
+        },
     }
-}
 
-func listEmoji() error {
-    if len(emojiList) == 0 {
-        fmt.Println("Loading emoji list from file...")
+    var statusSyncCmd = &cobra.Command{
+        Use:   "sync",
+        Short: "Set status and DND from the calendar event in progress (run from cron)",
+        Args:  cobra.NoArgs,
+        Run: func(cmd *cobra.Command, args []string) {
+            icsFile, _ := cmd.Flags().GetString("ics")
+            nowValue, _ := cmd.Flags().GetString("now")
+            dryRun, _ := cmd.Flags().GetBool("dry-run")
+            if icsFile == "" {
+                fmt.Println("Error: --ics is required")
+                return
+            }
+            now := time.Now()
+            if nowValue != "" {
+                parsed, err := time.ParseInLocation("2006-01-02 15:04", nowValue, time.Local)
+                if err != nil {
+                    fmt.Println("Invalid --now value. Use \"YYYY-MM-DD HH:MM\".")
+                    return
+                }
+                now = parsed
+            }
+            if err := syncCalendarStatus(icsFile, now, dryRun); err != nil {
+                fmt.Println("Error syncing status:", err)
+            }
+        },
+    }
+    statusSyncCmd.Flags().String("ics", "", "iCalendar (.ics) file to read events from")
+    statusSyncCmd.Flags().String("now", "", "Evaluate the calendar at this time instead of now (\"YYYY-MM-DD HH:MM\")")
+    statusSyncCmd.Flags().Bool("dry-run", false, "Print what would change without calling Slack")
+
+    statusCmd.AddCommand(statusSetCmd)
+    statusCmd.AddCommand(statusClearCmd)
+    statusCmd.AddCommand(statusPresetsCmd)
+    statusCmd.AddCommand(statusSyncCmd)
+
+    var dndCmd = &cobra.Command{
+        Use:   "dnd",
+        Short: "Control Do Not Disturb",
+    }
 
-        err := loadEmojiConfig()
-        if err != nil {
-            return fmt.Errorf("Error loading emoji list from file: %v", err)
-        }
+    var dndOnCmd = &cobra.Command{
+        Use:   "on [duration]",
+        Short: "Snooze notifications (default 1h)",
+        Args:  cobra.MaximumNArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            duration := time.Hour
+            if len(args) > 0 {
+                parsed, err := parseDuration(args[0])
+                if err != nil {
+                    fmt.Println("Error:", err)
+                    return
+                }
+                duration = parsed
+            }
+            minutes := int(duration.Minutes())
+            if minutes < 1 {
+                fmt.Println("Error: duration must be at least 1 minute")
+                return
+            }
+            if err := setSnooze(minutes); err != nil {
+                fmt.Println("Error snoozing notifications:", err)
+            }
+        },
+    }
 
-        fmt.Println("Emoji list loaded successfully")
+    var dndOffCmd = &cobra.Command{
+        Use:   "off",
+        Short: "Turn Do Not Disturb off",
+        Args:  cobra.NoArgs,
+        Run: func(cmd *cobra.Command, args []string) {
+            if err := endDND(); err != nil {
+                fmt.Println("Error turning off Do Not Disturb:", err)
+            }
+        },
     }
 
-    fmt.Println("Emoji List:")
-    for name, code := range emojiList {
-        emoji, err := unicodeToEmoji(code)
-        if err != nil {
-            fmt.Printf("%s: %s (failed to convert)\n", name, code)
-        } else {
-            fmt.Printf("%s: %s\n", name, emoji)
-        }
+    var dndStatusCmd = &cobra.Command{
+        Use:   "status [@user]",
+        Short: "Show Do Not Disturb status for you or a teammate",
+        Args:  cobra.MaximumNArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            target := ""
+            if len(args) > 0 {
+                target = args[0]
+            }
+            if err := showDNDStatus(target); err != nil {
+                fmt.Println("Error getting Do Not Disturb status:", err)
+            }
+        },
     }
 
-    return nil
-}
+    dndCmd.AddCommand(dndOnCmd)
+    dndCmd.AddCommand(dndOffCmd)
+    dndCmd.AddCommand(dndStatusCmd)
 
-func unicodeToEmoji(unicodeStr string) (string, error) {
-    unicodeStr = strings.ReplaceAll(unicodeStr, "&#x", "")
-    unicodeStr = strings.ReplaceAll(unicodeStr, ";", "")
-    runeValue, err := strconv.ParseInt(unicodeStr, 16, 32)
-    if err != nil {
-        return "", err
+    var execCmd = &cobra.Command{
+        Use:   "exec -- [command] [args...]",
+        Short: "Run a command and post its output, exit code and duration",
+        Args:  cobra.MinimumNArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            threadTS, _ := cmd.Flags().GetString("ts")
+            maxLines, _ := cmd.Flags().GetInt("lines")
+            upload, _ := cmd.Flags().GetBool("upload")
+            live, _ := cmd.Flags().GetBool("live")
+            interval, _ := cmd.Flags().GetDuration("interval")
+            os.Exit(execAndReport(args, threadTS, maxLines, upload, live, interval))
+        },
     }
-    return string(rune(runeValue)), nil
-}
+    execCmd.Flags().String("ts", "", "Thread timestamp")
+    execCmd.Flags().Int("lines", 40, "Number of output lines to include in the message")
+    execCmd.Flags().Bool("upload", true, "Attach the full output as a file when it is truncated")
+    execCmd.Flags().Bool("live", false, "Post immediately and update the message while the command runs")
+    execCmd.Flags().Duration("interval", 5*time.Second, "Minimum time between live updates")
+    // Flags after the command belong to it, even without "--".
+    execCmd.Flags().SetInterspersed(false)
+
+    var streamCmd = &cobra.Command{
+        Use:   "stream [title]",
+        Short: "Stream stdin into a single, periodically updated message",
+        Args:  cobra.MaximumNArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            title := ""
+            if len(args) > 0 {
+                title = args[0]
+            }
+            threadTS, _ := cmd.Flags().GetString("ts")
+            maxLines, _ := cmd.Flags().GetInt("lines")
+            interval, _ := cmd.Flags().GetDuration("interval")
+            if err := streamStdin(title, threadTS, maxLines, interval); err != nil {
+                fmt.Println("Error streaming output:", err)
+            }
+        },
+    }
+    streamCmd.Flags().String("ts", "", "Thread timestamp")
+    streamCmd.Flags().Int("lines", 20, "Number of output lines shown in the message")
+    streamCmd.Flags().Duration("interval", 5*time.Second, "Minimum time between updates")
 
-func main() {
-    checkAndLoadConfig()
+    var jobCmd = &cobra.Command{
+        Use:   "job",
+        Short: "Report progress of a long-running job in a single status message",
+    }
+    jobCmd.PersistentFlags().String("job", "default", "Name the job is tracked under")
 
-    fmt.Printf("Slack CLI (build time: %s)\n", buildTime)
+    var jobStartCmd = &cobra.Command{
+        Use:   "start [title]",
+        Short: "Post the status message of a new job",
+        Args:  cobra.ExactArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            name, _ := cmd.Flags().GetString("job")
+            if err := startJob(name, args[0]); err != nil {
+                fmt.Println("Error starting job:", err)
+            }
+        },
+    }
 
-    var rootCmd = &cobra.Command{Use: "slack"}
+    var jobStepCmd = &cobra.Command{
+        Use:   "step [text]",
+        Short: "Add a checklist line to the job's status message",
+        Args:  cobra.ExactArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            name, _ := cmd.Flags().GetString("job")
+            failed, _ := cmd.Flags().GetBool("fail")
+            if err := addJobStep(name, args[0], failed); err != nil {
+                fmt.Println("Error adding job step:", err)
+            }
+        },
+    }
+    jobStepCmd.Flags().Bool("fail", false, "Mark the step as failed")
 
-    var sendCmd = &cobra.Command{
-        Use:   "send [message]",
-        Short: "Send a message to Slack",
+    var jobFinishCmd = &cobra.Command{
+        Use:   "finish",
+        Short: "Mark the job as succeeded (--ok) or failed (--fail)",
+        Args:  cobra.NoArgs,
         Run: func(cmd *cobra.Command, args []string) {
-            if len(args) < 1 {
-                fmt.Println("Error: message is required")
+            name, _ := cmd.Flags().GetString("job")
+            ok, _ := cmd.Flags().GetBool("ok")
+            failed, _ := cmd.Flags().GetBool("fail")
+            if ok == failed {
+                fmt.Println("Error: use either --ok or --fail")
                 return
             }
-            threadTS, _ := cmd.Flags().GetString("ts")
-            sendMessage(args[0], threadTS)
+            if err := finishJob(name, ok); err != nil {
+                fmt.Println("Error finishing job:", err)
+            }
         },
     }
-    sendCmd.Flags().String("ts", "", "Thread timestamp")
+    jobFinishCmd.Flags().Bool("ok", false, "The job succeeded")
+    jobFinishCmd.Flags().Bool("fail", false, "The job failed")
 
-    var showCmd = &cobra.Command{
-        Use:   "show [limit]",
-        Short: "Get messages from Slack",
-        Args:  cobra.MaximumNArgs(1),
+    var jobListCmd = &cobra.Command{
+        Use:   "list",
+        Short: "List running jobs",
+        Args:  cobra.NoArgs,
         Run: func(cmd *cobra.Command, args []string) {
-            var limit int
-            if len(args) > 0 {
-                parsedLimit, err := strconv.Atoi(args[0])
-                if err != nil {
-                    fmt.Println("Invalid limit value, using default value.")
-                    limit = config.DefaultShowLimit
-                } else {
-                    limit = parsedLimit
-                }
-            } else {
-                limit, _ = cmd.Flags().GetInt("limit")
+            if err := listJobs(); err != nil {
+                fmt.Println("Error listing jobs:", err)
             }
-            date, _ := cmd.Flags().GetString("date")
-            search, _ := cmd.Flags().GetString("search")
-            filter, _ := cmd.Flags().GetString("filter")
-            showFilesOnly, _ := cmd.Flags().GetBool("files")
-            fetchMessages(limit, date, search, filter, showFilesOnly)
         },
     }
-    showCmd.Flags().String("date", "", "Date or date range for filtering messages (YYYY-MM-DD or YYYY-MM-DD:YYYY-MM-DD)")
-    showCmd.Flags().String("search", "", "Keyword to search in messages")
-    showCmd.Flags().String("filter", "", "Keyword to filter messages")
-    showCmd.Flags().Int("limit", config.DefaultShowLimit, "Limit the number of messages to retrieve")
-    showCmd.Flags().Bool("files", false, "Show only messages with files")
 
-    var uploadCmd = &cobra.Command{
-        Use:   "upload [filePath]",
-        Short: "Upload a file to Slack",
+    jobCmd.AddCommand(jobStartCmd)
+    jobCmd.AddCommand(jobStepCmd)
+    jobCmd.AddCommand(jobFinishCmd)
+    jobCmd.AddCommand(jobListCmd)
+
+    var watchFileCmd = &cobra.Command{
+        Use:   "watch-file [path]",
+        Short: "Follow a log file and post matching lines in batches",
+        Args:  cobra.ExactArgs(1),
         Run: func(cmd *cobra.Command, args []string) {
-            if len(args) < 1 {
-                fmt.Println("Error: file path is required")
-                return
+            pattern, _ := cmd.Flags().GetString("match")
+            window, _ := cmd.Flags().GetDuration("window")
+            thread, _ := cmd.Flags().GetBool("thread")
+            fromStart, _ := cmd.Flags().GetBool("from-start")
+            if err := watchFile(args[0], pattern, window, thread, fromStart); err != nil {
+                fmt.Println("Error watching file:", err)
             }
-            uploadFile(args[0])
         },
     }
+    watchFileCmd.Flags().String("match", "", "Regular expression lines must match (default: all lines)")
+    watchFileCmd.Flags().Duration("window", 30*time.Second, "Collect matching lines for this long before posting")
+    watchFileCmd.Flags().Bool("thread", false, "Post batches as replies to a single message")
+    watchFileCmd.Flags().Bool("from-start", false, "On the first run, read the file from the beginning instead of the end")
+
+    var scheduledCmd = &cobra.Command{
+        Use:   "scheduled",
+        Short: "Manage messages scheduled with send --at/--in",
+    }
 
-    var downloadCmd = &cobra.Command{
-        Use:   "download [fileURL]",
-        Short: "Download a file from Slack",
+    var scheduledListCmd = &cobra.Command{
+        Use:   "list",
+        Short: "List pending scheduled messages (all channels unless --channel is given)",
+        Args:  cobra.NoArgs,
         Run: func(cmd *cobra.Command, args []string) {
-            if len(args) < 1 {
-                fmt.Println("Error: file URL is required")
-                return
+            if err := listScheduledMessages(); err != nil {
+                fmt.Println("Error listing scheduled messages:", err)
             }
-            getFile(args[0])
         },
     }
 
-    var emojiCmd = &cobra.Command{
-        Use:   "emoji [ts] [emoji]",
-        Short: "Add or remove a reaction to a Slack message",
+    var scheduledDeleteCmd = &cobra.Command{
+        Use:   "delete <id>",
+        Short: "Cancel a scheduled message",
+        Args:  cobra.ExactArgs(1),
         Run: func(cmd *cobra.Command, args []string) {
-            ts := ""
-            emoji := ""
-            if len(args) > 0 {
-                ts = args[0]
+            if err := deleteScheduledMessage(args[0]); err != nil {
+                fmt.Println("Error deleting scheduled message:", err)
             }
-            if len(args) > 1 {
-                emoji = args[1]
+        },
+    }
+
+    scheduledCmd.AddCommand(scheduledListCmd)
+    scheduledCmd.AddCommand(scheduledDeleteCmd)
+
+    var schedulerCmd = &cobra.Command{
+        Use:   "scheduler <file>",
+        Short: "Post recurring messages from a file of cron expressions (runs in the foreground)",
+        Args:  cobra.ExactArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            catchUp, _ := cmd.Flags().GetString("catch-up")
+            dryRun, _ := cmd.Flags().GetBool("dry-run")
+            if err := runScheduler(args[0], catchUp, dryRun); err != nil {
+                fmt.Println("Error running scheduler:", err)
             }
-            add, _ := cmd.Flags().GetString("add")
-            del, _ := cmd.Flags().GetString("del")
-            handleEmoji(ts, emoji, add, del)
         },
     }
-    emojiCmd.Flags().String("add", "", "Add a reaction to the message")
-    emojiCmd.Flags().String("del", "", "Remove a reaction from the message")
+    schedulerCmd.Flags().String("catch-up", "skip", "Runs missed while stopped: skip, last (post the latest once) or all")
+    schedulerCmd.Flags().Bool("dry-run", false, "Check the file and show the next run of each entry")
 
-    var editCmd = &cobra.Command{
-        Use:   "edit [ts] [message]",
-        Short: "Update a Slack message",
+    var outboxCmd = &cobra.Command{
+        Use:   "outbox",
+        Short: "Manage messages queued with send --queue",
+    }
+
+    var outboxListCmd = &cobra.Command{
+        Use:   "list",
+        Short: "List queued messages",
+        Args:  cobra.NoArgs,
         Run: func(cmd *cobra.Command, args []string) {
-            if len(args) < 2 {
-                fmt.Println("Error: ts (timestamp) and message are required")
-                return
+            if err := listOutbox(); err != nil {
+                fmt.Println("Error listing outbox:", err)
             }
-            updateMessage(args[0], args[1])
         },
     }
 
-    var deleteCmd = &cobra.Command{
-        Use:   "delete [ts]",
-        Short: "Delete a Slack message",
+    var outboxFlushCmd = &cobra.Command{
+        Use:   "flush",
+        Short: "Retry delivering queued messages",
+        Args:  cobra.NoArgs,
         Run: func(cmd *cobra.Command, args []string) {
-            if len(args) < 1 {
-                fmt.Println("Error: ts (timestamp) is required")
-                return
+            if err := flushOutbox(false); err != nil {
+                fmt.Println("Error flushing outbox:", err)
             }
-            deleteMessage(args[0])
         },
     }
 
-    var channelsCmd = &cobra.Command{
-        Use:   "channels",
-        Short: "List all Slack channels and cache them",
+    var outboxDropCmd = &cobra.Command{
+        Use:   "drop [id...]",
+        Short: "Remove queued messages (--all to remove everything)",
         Run: func(cmd *cobra.Command, args []string) {
-            currentChannel, _ := cmd.Flags().GetString("current")
-            
-            if currentChannel == "" {
-                channelCache, err := getChannelList()
-                if err != nil {
-                    fmt.Println("Error fetching channel list:", err)
-                    return
-                }
-        
-                fmt.Println("Channels:")
-                for id, name := range channelCache {
-                    fmt.Printf("%s: %s\n", id, name)
-                }
-        
-                fmt.Printf("Current default channel: %s (%s)\n", config.ChannelCache[config.ChannelID], config.ChannelID)
-            } else {
-                var channelID string
-                for id, name := range config.ChannelCache {
-                    if name == currentChannel {
-                        channelID = id
-                        break
-                    }
-                }
-                if channelID == "" {
-                    fmt.Printf("Channel %s not found\n", currentChannel)
-                    return
-                }
-                config.ChannelID = channelID
-                err := saveConfig()
-                if err != nil {
-                    fmt.Println("Error saving config file:", err)
-                    return
-                }
-                fmt.Printf("Default channel set to %s (%s)\n", currentChannel, channelID)
+            all, _ := cmd.Flags().GetBool("all")
+            if len(args) == 0 && !all {
+                fmt.Println("Error: give message IDs or --all")
+                return
+            }
+            if err := dropOutbox(args); err != nil {
+                fmt.Println("Error dropping messages:", err)
             }
         },
     }
-    
-    channelsCmd.Flags().String("current", "", "Get or set the default channel by name")
-    
-    
-    
+    outboxDropCmd.Flags().Bool("all", false, "Remove all queued messages")
+
+    outboxCmd.AddCommand(outboxListCmd)
+    outboxCmd.AddCommand(outboxFlushCmd)
+    outboxCmd.AddCommand(outboxDropCmd)
+
 
     var examplesCmd = &cobra.Command{
         Use:   "examples",
@@ -1212,12 +7966,91 @@ func main() {
    ./slack show 500 --search "keyword"
    ./slack show --filter "keyword"
    ./slack show 500 --filter "keyword"
+   ./slack show 500 --filter "keyword" -C 2
+   ./slack show 500 --filter "keyword" -B 3 -A 1
    ./slack show --files
+   ./slack search "deploy failed"
+   ./slack search "in:#ops from:@bob after:2024-01-01 has:link" --sort time
+   ./slack search "report" --files --limit 50
    ./slack channels
+   ./slack channels --types public,private --member-only
+   ./slack channels --archived --sort members
    ./slack channels --current
    ./slack channels --current channel_name
+   ./slack channels --current chanel_nme (fuzzy suggestions)
+   ./slack channels --recent
+   ./slack channels create release-2024 --private
+   ./slack channels archive "#old-project"
+   ./slack channels unarchive old-project
+   ./slack channels rename new-name --channel "#old-name"
+   ./slack channels join "#ops"
+   ./slack channels leave
+   ./slack channels invite @alice @bob
+   ./slack channels kick @alice
+   ./slack channels topic "Release day"
+   ./slack channels purpose "Coordination for releases"
+   ./slack channels sync members.yaml
+   ./slack channels sync members.yaml --apply
+   ./slack users list
+   ./slack users list --active --presence
+   ./slack users search alice
+   ./slack users info @alice
+   ./slack users info U0123456789
+   ./slack status
+   ./slack status set ":palm_tree: Vacation" --until 2024-08-10
+   ./slack status set "In a meeting" --emoji :calendar: --for 45m
+   ./slack status set lunch
+   ./slack status presets
+   ./slack status clear
+   ./slack status sync --ics calendar.ics
+   ./slack status sync --ics testdata/calendar.ics --now "2024-06-03 10:15" --dry-run
+   ./slack dnd on 45m
+   ./slack dnd off
+   ./slack dnd status
+   ./slack dnd status @alice
    ./slack send "Hello, Slack!"
    ./slack send "Hello, Slack!" --ts 1234567890.123456 (reply)
+   ./slack send --file msg.txt
+   make test 2>&1 | ./slack send -
+   ./slack send (compose in $EDITOR)
+   ./slack send "Disk almost full on db1" --dedupe-key disk-db1 --window 10m
+   ./slack send "Disk almost full on db1" --dedupe-key disk-db1 --dedupe-mode thread
+   ./slack send "@alice @oncall please check #ops" --preview
+   ./slack send "@here deploy starts in 5 minutes"
+   ./slack send --file RELEASE_NOTES.md --markdown
+   ./slack send --file RELEASE_NOTES.md --markdown --sections --preview
+   ./slack send --blocks deploy.json
+   ./slack send "Deploy finished" --blocks deploy.json --attachments details.json
+   ./slack edit 1234567890.123456 --blocks deploy-done.json
+   ./slack send --template handover --var next=alice --preview
+   ./slack send --template release --vars release.json
+   ./slack send "Release 2.4 is out" --at "2024-06-01 09:00"
+   ./slack send "Standup in 5 minutes" --in 2h
+   ./slack scheduled list
+   ./slack scheduled delete Q1298393284
+   ./slack scheduler testdata/schedule.txt --dry-run
+   ./slack scheduler testdata/schedule.txt --catch-up last
+   ./slack send "Deploy finished" --queue
+   ./slack outbox list
+   ./slack outbox flush
+   ./slack outbox drop 3f2a9c0d1e4b5a67
+   ./slack exec -- make deploy
+   ./slack exec --lines 20 --ts 1234567890.123456 -- ./run-tests.sh
+   ./slack exec --live -- ./deploy.sh production
+   ./deploy.sh 2>&1 | ./slack stream "Deploying to production"
+   ./slack job start "Nightly ETL" --job etl
+   ./slack job step "extract done" --job etl
+   ./slack job step "load failed" --fail --job etl
+   ./slack job finish --fail --job etl
+   ./slack job list
+   ./slack watch-file /var/log/app.log --match ERROR
+   ./slack watch-file /var/log/app.log --match "ERROR|FATAL" --window 1m --thread
+   ./slack send "Hello, ops!" --channel "#ops"
+   ./slack send "Hi!" --channel @alice
+   ./slack send "Hi both!" --channel @alice,@bob
+   ./slack show --channel @alice
+   ./slack channels --current @alice
+   ./slack show 50 --channel C0123456789
    ./slack edit --ts 1234567890.123456 --msg "Updated message"
    ./slack edit 1234567890.123456 "Updated message"
    ./slack delete --ts 1234567890.123456
@@ -1242,6 +8075,17 @@ func main() {
     rootCmd.AddCommand(deleteCmd)
     rootCmd.AddCommand(examplesCmd)
     rootCmd.AddCommand(channelsCmd)
+    rootCmd.AddCommand(searchCmd)
+    rootCmd.AddCommand(usersCmd)
+    rootCmd.AddCommand(statusCmd)
+    rootCmd.AddCommand(dndCmd)
+    rootCmd.AddCommand(execCmd)
+    rootCmd.AddCommand(streamCmd)
+    rootCmd.AddCommand(jobCmd)
+    rootCmd.AddCommand(watchFileCmd)
+    rootCmd.AddCommand(outboxCmd)
+    rootCmd.AddCommand(scheduledCmd)
+    rootCmd.AddCommand(schedulerCmd)
 
     // Remove the 'help' command or add it at the end if needed
     rootCmd.SetHelpCommand(&cobra.Command{Hidden: true})
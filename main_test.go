@@ -0,0 +1,1280 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "runtime"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "text/template"
+    "time"
+
+    "github.com/spf13/cobra"
+)
+
+// slackCall is one request received by the fake Slack server.
+type slackCall struct {
+    method string
+    token  string
+    params map[string]interface{}
+}
+
+// fakeSlack is a local Slack Web API. handle returns the response for a
+// method; "ok": true is added unless the response sets "ok".
+type fakeSlack struct {
+    mu    sync.Mutex
+    calls []slackCall
+}
+
+// newFakeSlack points the API at a local server for the rest of the test and
+// runs the test in a temporary directory with a fresh config and state.
+func newFakeSlack(t *testing.T, handle func(method string, params map[string]interface{}) map[string]interface{}) *fakeSlack {
+    fake := &fakeSlack{}
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        method := strings.TrimPrefix(r.URL.Path, "/")
+        params := make(map[string]interface{})
+        for key, values := range r.URL.Query() {
+            params[key] = values[0]
+        }
+        if r.Method == "POST" {
+            json.NewDecoder(r.Body).Decode(&params)
+        }
+        fake.mu.Lock()
+        fake.calls = append(fake.calls, slackCall{method, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), params})
+        fake.mu.Unlock()
+
+        var response map[string]interface{}
+        if handle != nil {
+            response = handle(method, params)
+        }
+        if response == nil {
+            response = make(map[string]interface{})
+        }
+        if _, exists := response["ok"]; !exists {
+            response["ok"] = true
+        }
+        json.NewEncoder(w).Encode(response)
+    }))
+
+    savedURL, savedConfig, savedTarget := slackAPIBaseURL, config, targetChannelID
+    slackAPIBaseURL = server.URL + "/"
+    config = Config{SlackUserToken: "xoxp-user", SlackBotToken: "xoxb-bot", ChannelID: "C0DEFAULT1"}
+    targetChannelID = ""
+    fetchedUsers = nil
+    useTempDir(t)
+    t.Cleanup(func() {
+        server.Close()
+        slackAPIBaseURL, config, targetChannelID = savedURL, savedConfig, savedTarget
+        fetchedUsers = nil
+    })
+    return fake
+}
+
+// called returns the calls made to method.
+func (fake *fakeSlack) called(method string) []slackCall {
+    fake.mu.Lock()
+    defer fake.mu.Unlock()
+    var calls []slackCall
+    for _, call := range fake.calls {
+        if call.method == method {
+            calls = append(calls, call)
+        }
+    }
+    return calls
+}
+
+// packageDir is the directory the tests started in, where testdata is.
+var packageDir, _ = os.Getwd()
+
+// useTempDir runs the rest of the test in an empty directory, so the config
+// and state files are not shared.
+func useTempDir(t *testing.T) {
+    err := os.Chdir(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { os.Chdir(packageDir) })
+}
+
+func TestParseCron(t *testing.T) {
+    tests := []struct {
+        spec    string
+        wantErr bool
+    }{
+        {"45 9 * * mon-fri", false},
+        {"0 16 * * fri", false},
+        {"*/15 8-18 * * *", false},
+        {"0 0 1,15 jan-jun 0", false},
+        {"0 0 * * 7", false},
+        {"@hourly", false},
+        {"@weekly", false},
+        {"* * * *", true},
+        {"60 * * * *", true},
+        {"0 24 * * *", true},
+        {"0 0 0 * *", true},
+        {"0 0 * 13 *", true},
+        {"0 0 * * funday", true},
+        {"0 0 * * 5-1", true},
+        {"*/0 * * * *", true},
+        {"@often", true},
+    }
+    for _, test := range tests {
+        _, err := parseCron(test.spec)
+        if (err != nil) != test.wantErr {
+            t.Errorf("parseCron(%q) error = %v, want error %v", test.spec, err, test.wantErr)
+        }
+    }
+}
+
+func TestCronScheduleNext(t *testing.T) {
+    date := func(year int, month time.Month, day, hour, minute int) time.Time {
+        return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+    }
+    tests := []struct {
+        spec string
+        from time.Time
+        want time.Time
+    }{
+        // 2024-06-07 is a Friday.
+        {"45 9 * * mon-fri", date(2024, 6, 7, 9, 44), date(2024, 6, 7, 9, 45)},
+        {"45 9 * * mon-fri", date(2024, 6, 7, 9, 45), date(2024, 6, 10, 9, 45)},
+        {"0 16 * * fri", date(2024, 6, 7, 16, 0), date(2024, 6, 14, 16, 0)},
+        {"0 10 1 * *", date(2024, 6, 15, 0, 0), date(2024, 7, 1, 10, 0)},
+        {"0 10 1 * *", date(2024, 12, 1, 10, 0), date(2025, 1, 1, 10, 0)},
+        {"@hourly", date(2024, 6, 7, 10, 30), date(2024, 6, 7, 11, 0)},
+        {"*/15 * * * *", date(2024, 6, 7, 10, 7), date(2024, 6, 7, 10, 15)},
+        {"0 0 * * 7", date(2024, 6, 7, 0, 0), date(2024, 6, 9, 0, 0)},
+        {"0 0 29 2 *", date(2024, 3, 1, 0, 0), date(2028, 2, 29, 0, 0)},
+        // With both day fields restricted, either one matches.
+        {"0 12 13 * fri", date(2024, 6, 1, 0, 0), date(2024, 6, 7, 12, 0)},
+        {"0 0 31 2 *", date(2024, 1, 1, 0, 0), time.Time{}},
+    }
+    for _, test := range tests {
+        schedule, err := parseCron(test.spec)
+        if err != nil {
+            t.Fatalf("parseCron(%q): %v", test.spec, err)
+        }
+        got := schedule.next(test.from)
+        if !got.Equal(test.want) {
+            t.Errorf("%q next after %s = %s, want %s", test.spec, test.from.Format(time.RFC3339), got.Format(time.RFC3339), test.want.Format(time.RFC3339))
+        }
+    }
+}
+
+func TestScheduleRuns(t *testing.T) {
+    hourly, err := parseCron("@hourly")
+    if err != nil {
+        t.Fatal(err)
+    }
+    last := time.Date(2024, 6, 7, 8, 0, 0, 0, time.UTC)
+    onTheHour := time.Date(2024, 6, 7, 12, 0, 0, 0, time.UTC)
+    between := time.Date(2024, 6, 7, 12, 30, 0, 0, time.UTC)
+
+    tests := []struct {
+        name        string
+        last        time.Time
+        now         time.Time
+        catchUp     string
+        wantRuns    []int
+        wantSkipped int
+    }{
+        {"nothing due", last, last.Add(30 * time.Minute), "all", nil, 0},
+        {"skip keeps the current run", last, onTheHour, "skip", []int{12}, 3},
+        {"skip drops missed runs", last, between, "skip", nil, 4},
+        {"last", last, between, "last", []int{12}, 3},
+        {"all", last, between, "all", []int{9, 10, 11, 12}, 0},
+    }
+    for _, test := range tests {
+        runs, skipped := scheduleRuns(hourly, test.last, test.now, test.catchUp)
+        if skipped != test.wantSkipped {
+            t.Errorf("%s: skipped = %d, want %d", test.name, skipped, test.wantSkipped)
+        }
+        var hours []int
+        for _, run := range runs {
+            hours = append(hours, run.Hour())
+        }
+        if len(hours) != len(test.wantRuns) {
+            t.Errorf("%s: runs at hours %v, want %v", test.name, hours, test.wantRuns)
+            continue
+        }
+        for i := range hours {
+            if hours[i] != test.wantRuns[i] {
+                t.Errorf("%s: runs at hours %v, want %v", test.name, hours, test.wantRuns)
+                break
+            }
+        }
+    }
+
+    // Two days and four hours of missed runs are cut to the most recent ones.
+    runs, skipped := scheduleRuns(hourly, last.AddDate(0, 0, -2), between, "all")
+    if len(runs) != schedulerMaxCatchUp || skipped != 52-schedulerMaxCatchUp {
+        t.Fatalf("all after two days: %d runs and %d skipped, want %d and %d", len(runs), skipped, schedulerMaxCatchUp, 52-schedulerMaxCatchUp)
+    }
+    if !runs[len(runs)-1].Equal(onTheHour) {
+        t.Errorf("all after two days: last run %s, want %s", runs[len(runs)-1], onTheHour)
+    }
+}
+
+func TestParseScheduleFile(t *testing.T) {
+    config.ChannelCache = map[string]string{
+        "C0STANDUP1": "standup",
+        "C0TEAM0001": "team",
+        "C0OPS00001": "ops",
+    }
+    defer func() { config.ChannelCache = nil }()
+
+    entries, err := parseScheduleFile("testdata/schedule.txt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []struct {
+        spec      string
+        channelID string
+    }{
+        {"45 9 * * mon-fri", "C0STANDUP1"},
+        {"0 16 * * fri", "C0TEAM0001"},
+        {"0 10 1 * *", "C0OPS00001"},
+        {"@hourly", "C0123456789"},
+    }
+    if len(entries) != len(want) {
+        t.Fatalf("got %d entries, want %d", len(entries), len(want))
+    }
+    for i, entry := range entries {
+        if entry.Spec != want[i].spec || entry.ChannelID != want[i].channelID {
+            t.Errorf("entry %d = %q %s, want %q %s", i, entry.Spec, entry.ChannelID, want[i].spec, want[i].channelID)
+        }
+    }
+
+    var rendered strings.Builder
+    runTime := time.Date(2024, 6, 7, 16, 0, 0, 0, time.UTC)
+    err = entries[1].Message.Execute(&rendered, ScheduleData{Time: runTime, Channel: entries[1].Channel})
+    if err != nil {
+        t.Fatal(err)
+    }
+    wantText := ":tada: Weekly wrap-up for Jun 7\nPlease post your highlights in the thread."
+    if rendered.String() != wantText {
+        t.Errorf("rendered %q, want %q", rendered.String(), wantText)
+    }
+}
+
+func TestRunDueScheduleEntriesRetriesFailedPosts(t *testing.T) {
+    failPosts := true
+    fake := newFakeSlack(t, func(method string, params map[string]interface{}) map[string]interface{} {
+        if method == "chat.postMessage" && failPosts {
+            return map[string]interface{}{"ok": false, "error": "ratelimited"}
+        }
+        return map[string]interface{}{"ts": "1.0"}
+    })
+
+    schedule, err := parseCron("0 9 * * *")
+    if err != nil {
+        t.Fatal(err)
+    }
+    message, err := template.New("line 1").Parse("Standup at {{.Time.Format \"15:04\"}}")
+    if err != nil {
+        t.Fatal(err)
+    }
+    entry := &ScheduleEntry{Key: "standup", Line: 1, Spec: "0 9 * * *", Channel: "#standup", ChannelID: "C0STANDUP1", Message: message, Schedule: schedule}
+    runTime := time.Date(2024, 6, 7, 9, 0, 0, 0, time.UTC)
+
+    // The first post fails and the run is kept for a retry.
+    err = runDueScheduleEntries([]*ScheduleEntry{entry}, runTime, "skip")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if saved := state.Schedules["standup"]; saved == nil || len(saved.Retry) != 1 || saved.Retry[0] != runTime.Unix() {
+        t.Fatalf("after a failed post the state is %+v, want the 09:00 run kept for a retry", saved)
+    }
+
+    // The next check, a minute later, posts it.
+    failPosts = false
+    err = runDueScheduleEntries([]*ScheduleEntry{entry}, runTime.Add(time.Minute), "skip")
+    if err != nil {
+        t.Fatal(err)
+    }
+    posts := fake.called("chat.postMessage")
+    if len(posts) != 2 {
+        t.Fatalf("got %d posts, want a failed one and its retry", len(posts))
+    }
+    if posts[1].params["channel"] != "C0STANDUP1" || posts[1].params["text"] != "Standup at 09:00" {
+        t.Errorf("retry posted %v", posts[1].params)
+    }
+    if saved := state.Schedules["standup"]; saved == nil || len(saved.Retry) != 0 {
+        t.Errorf("after the retry the state is %+v, want nothing left to retry", saved)
+    }
+
+    // Nothing is posted twice on the following check.
+    err = runDueScheduleEntries([]*ScheduleEntry{entry}, runTime.Add(2*time.Minute), "skip")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if posts := fake.called("chat.postMessage"); len(posts) != 2 {
+        t.Errorf("got %d posts after a quiet check, want 2", len(posts))
+    }
+}
+
+func TestFinishJobCanBeRetried(t *testing.T) {
+    failUpdates := true
+    fake := newFakeSlack(t, func(method string, params map[string]interface{}) map[string]interface{} {
+        if method == "chat.update" && failUpdates {
+            return map[string]interface{}{"ok": false, "error": "internal_error"}
+        }
+        return map[string]interface{}{"ts": "1700000000.000100"}
+    })
+
+    err := startJob("deploy", "Deploy api")
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = finishJob("deploy", true)
+    if err == nil {
+        t.Fatal("finishJob succeeded although chat.update failed")
+    }
+    loadState()
+    if _, exists := state.Jobs["deploy"]; !exists {
+        t.Fatal("the job was forgotten after a failed finish")
+    }
+
+    failUpdates = false
+    err = finishJob("deploy", true)
+    if err != nil {
+        t.Fatalf("retrying the finish: %v", err)
+    }
+    loadState()
+    if _, exists := state.Jobs["deploy"]; exists {
+        t.Error("the job is still running after a successful finish")
+    }
+    if reactions := fake.called("reactions.add"); len(reactions) != 1 || reactions[0].params["name"] != "white_check_mark" {
+        t.Errorf("reactions.add calls = %v, want one white_check_mark", reactions)
+    }
+}
+
+func readCalendarFixture(t *testing.T) []CalendarEvent {
+    file, err := os.Open("testdata/calendar.ics")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer file.Close()
+    events, err := parseICS(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return events
+}
+
+func TestParseICS(t *testing.T) {
+    events := readCalendarFixture(t)
+    berlin, err := time.LoadLocation("Europe/Berlin")
+    if err != nil {
+        t.Skip("time zone data not available:", err)
+    }
+
+    // The cancelled review and the cancelled standup override are left out.
+    want := []struct {
+        uid     string
+        summary string
+        start   time.Time
+        end     time.Time
+        allDay  bool
+    }{
+        {"standup@example.com", "Daily standup", time.Date(2024, 6, 3, 10, 0, 0, 0, berlin), time.Date(2024, 6, 3, 10, 15, 0, 0, berlin), false},
+        {"standup@example.com", "Daily standup (moved)", time.Date(2024, 6, 7, 11, 30, 0, 0, berlin), time.Date(2024, 6, 7, 11, 45, 0, 0, berlin), false},
+        {"retro@example.com", "Retro", time.Date(2024, 6, 21, 15, 0, 0, 0, time.UTC), time.Date(2024, 6, 21, 16, 0, 0, 0, time.UTC), false},
+        {"planning@example.com", "Sprint planning, Q3", time.Date(2024, 6, 3, 8, 30, 0, 0, time.UTC), time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC), false},
+        {"one-on-one@example.com", "1:1 with Alice", time.Date(2024, 6, 4, 14, 0, 0, 0, berlin), time.Date(2024, 6, 4, 14, 30, 0, 0, berlin), false},
+        {"vacation@example.com", "Vacation", time.Date(2024, 6, 10, 0, 0, 0, 0, time.Local), time.Date(2024, 6, 15, 0, 0, 0, 0, time.Local), true},
+    }
+    if len(events) != len(want) {
+        t.Fatalf("got %d events, want %d", len(events), len(want))
+    }
+    for i, event := range events {
+        if event.UID != want[i].uid || event.Summary != want[i].summary || event.AllDay != want[i].allDay {
+            t.Errorf("event %d = %s %q all-day %v, want %s %q all-day %v", i, event.UID, event.Summary, event.AllDay, want[i].uid, want[i].summary, want[i].allDay)
+        }
+        if !event.Start.Equal(want[i].start) || !event.End.Equal(want[i].end) {
+            t.Errorf("%s runs %s to %s, want %s to %s", event.UID, event.Start, event.End, want[i].start, want[i].end)
+        }
+    }
+    if events[0].RRule["FREQ"] != "WEEKLY" || events[0].RRule["BYDAY"] != "MO,TU,WE,TH,FR" {
+        t.Errorf("standup RRULE = %v", events[0].RRule)
+    }
+
+    // The EXDATE and both overrides, including the cancelled one, remove
+    // occurrences from the recurring standup.
+    wantExDates := []time.Time{
+        time.Date(2024, 6, 6, 10, 0, 0, 0, berlin),
+        time.Date(2024, 6, 7, 10, 0, 0, 0, berlin),
+        time.Date(2024, 6, 17, 10, 0, 0, 0, berlin),
+    }
+    if len(events[0].ExDates) != len(wantExDates) {
+        t.Fatalf("standup ExDates = %v, want %v", events[0].ExDates, wantExDates)
+    }
+    for i, exDate := range events[0].ExDates {
+        if !exDate.Equal(wantExDates[i]) {
+            t.Errorf("standup ExDates[%d] = %s, want %s", i, exDate, wantExDates[i])
+        }
+    }
+    if !events[1].RecurrenceID.Equal(wantExDates[1]) || events[1].RRule != nil {
+        t.Errorf("override RECURRENCE-ID = %s with RRULE %v, want %s without RRULE", events[1].RecurrenceID, events[1].RRule, wantExDates[1])
+    }
+}
+
+func TestCalendarStatus(t *testing.T) {
+    events := readCalendarFixture(t)
+    saved := config.CalendarRules
+    defer func() { config.CalendarRules = saved }()
+    config.CalendarRules = []CalendarRule{
+        {Keyword: "1:1", Emoji: "speech_balloon", DND: false},
+        {Keyword: "vacation", Emoji: ":palm_tree:", Text: "Out of office", DND: true},
+        {Emoji: "calendar", DND: true},
+    }
+
+    utc := func(month time.Month, day, hour, minute int) time.Time {
+        return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+    }
+    local := func(month time.Month, day, hour, minute int) time.Time {
+        return time.Date(2024, month, day, hour, minute, 0, 0, time.Local)
+    }
+    tests := []struct {
+        name  string
+        now   time.Time
+        uid   string
+        emoji string
+        text  string
+        end   time.Time
+        dnd   bool
+    }{
+        {"first standup", utc(6, 3, 8, 5), "standup@example.com", ":calendar:", "Daily standup", utc(6, 3, 8, 15), true},
+        {"between meetings", utc(6, 3, 8, 20), "", "", "", time.Time{}, false},
+        {"planning", utc(6, 3, 9, 30), "planning@example.com", ":calendar:", "Sprint planning, Q3", utc(6, 3, 10, 0), true},
+        {"recurring standup", utc(6, 5, 8, 14), "standup@example.com", ":calendar:", "Daily standup", utc(6, 5, 8, 15), true},
+        {"standup end is exclusive", utc(6, 5, 8, 15), "", "", "", time.Time{}, false},
+        {"no standup on saturday", utc(6, 8, 8, 5), "", "", "", time.Time{}, false},
+        {"excluded standup", utc(6, 6, 8, 5), "", "", "", time.Time{}, false},
+        {"moved standup at its old time", utc(6, 7, 8, 5), "", "", "", time.Time{}, false},
+        {"moved standup", utc(6, 7, 9, 35), "standup@example.com", ":calendar:", "Daily standup (moved)", utc(6, 7, 9, 45), true},
+        {"cancelled standup", utc(6, 17, 8, 5), "", "", "", time.Time{}, false},
+        {"standup after the cancelled one", utc(6, 18, 8, 5), "standup@example.com", ":calendar:", "Daily standup", utc(6, 18, 8, 15), true},
+        // The retro starts on Friday 2024-06-21 and runs every other
+        // calendar week on Monday and Friday.
+        {"first retro", utc(6, 21, 15, 30), "retro@example.com", ":calendar:", "Retro", utc(6, 21, 16, 0), true},
+        {"no retro in the off week", utc(6, 24, 15, 30), "", "", "", time.Time{}, false},
+        {"no retro on friday of the off week", utc(6, 28, 15, 30), "", "", "", time.Time{}, false},
+        {"retro on monday two weeks later", utc(7, 1, 15, 30), "retro@example.com", ":calendar:", "Retro", utc(7, 1, 16, 0), true},
+        {"retro on friday two weeks later", utc(7, 5, 15, 30), "retro@example.com", ":calendar:", "Retro", utc(7, 5, 16, 0), true},
+        {"keyword rule without dnd", utc(6, 4, 12, 10), "one-on-one@example.com", ":speech_balloon:", "1:1 with Alice", utc(6, 4, 12, 30), false},
+        {"all-day event", local(6, 12, 12, 0), "vacation@example.com", ":palm_tree:", "Out of office", local(6, 15, 0, 0), false},
+        {"keyword beats timed event", utc(6, 11, 8, 5), "vacation@example.com", ":palm_tree:", "Out of office", local(6, 15, 0, 0), false},
+        {"all-day end is exclusive", local(6, 15, 12, 0), "", "", "", time.Time{}, false},
+    }
+    for _, test := range tests {
+        event, ok := activeCalendarEvent(events, test.now)
+        if !ok {
+            if test.uid != "" {
+                t.Errorf("%s: no event in progress, want %s", test.name, test.uid)
+            }
+            continue
+        }
+        if test.uid == "" {
+            t.Errorf("%s: got %s in progress, want none", test.name, event.UID)
+            continue
+        }
+        status := calendarStatusFor(event)
+        if event.UID != test.uid || status.Emoji != test.emoji || status.Text != test.text || status.End != test.end.Unix() || status.DND != test.dnd {
+            t.Errorf("%s: got %s %s %q until %s dnd %v, want %s %s %q until %s dnd %v", test.name,
+                event.UID, status.Emoji, status.Text, time.Unix(status.End, 0).UTC(), status.DND,
+                test.uid, test.emoji, test.text, test.end.UTC(), test.dnd)
+        }
+    }
+
+    // Without keyword rules a timed meeting wins over an all-day event.
+    config.CalendarRules = nil
+    event, ok := activeCalendarEvent(events, utc(6, 11, 8, 5))
+    if !ok || event.UID != "standup@example.com" {
+        t.Errorf("without rules got %s (%v), want standup@example.com", event.UID, ok)
+    }
+    if status := calendarStatusFor(event); status.Emoji != ":calendar:" || !status.DND {
+        t.Errorf("default rule gave %s dnd %v, want :calendar: with dnd", status.Emoji, status.DND)
+    }
+}
+
+func TestSyncCalendarStatusEndsItsSnooze(t *testing.T) {
+    profile := map[string]interface{}{}
+    fake := newFakeSlack(t, func(method string, params map[string]interface{}) map[string]interface{} {
+        switch method {
+        case "users.profile.set":
+            profile = params["profile"].(map[string]interface{})
+        case "users.profile.get":
+            return map[string]interface{}{"profile": profile}
+        }
+        return nil
+    })
+    savedEmoji := emojiList
+    defer func() { emojiList = savedEmoji }()
+    emojiList = map[string]string{"calendar": "", "speech_balloon": ""}
+    config.CalendarRules = []CalendarRule{
+        {Keyword: "1:1", Emoji: "speech_balloon"},
+        {Emoji: "calendar", DND: true},
+    }
+    icsFile := filepath.Join(packageDir, "testdata", "calendar.ics")
+    runSync := func(now time.Time) {
+        t.Helper()
+        err := syncCalendarStatus(icsFile, now, false)
+        if err != nil {
+            t.Fatalf("sync at %s: %v", now, err)
+        }
+    }
+
+    // A meeting with DND snoozes notifications until it ends.
+    runSync(time.Date(2024, 6, 4, 8, 5, 0, 0, time.UTC))
+    if snoozes := fake.called("dnd.setSnooze"); len(snoozes) != 1 || snoozes[0].params["num_minutes"] != float64(10) {
+        t.Fatalf("dnd.setSnooze calls = %v, want one for 10 minutes", snoozes)
+    }
+
+    // The next event, the 1:1, has no DND, so the snooze the standup set is
+    // ended.
+    runSync(time.Date(2024, 6, 4, 12, 0, 0, 0, time.UTC))
+    if ends := fake.called("dnd.endSnooze"); len(ends) != 1 {
+        t.Fatalf("got %d dnd.endSnooze calls after switching to an event without DND, want 1", len(ends))
+    }
+    if state.CalendarStatus == nil || state.CalendarStatus.DND {
+        t.Errorf("state after the 1:1 started = %+v, want a status without DND", state.CalendarStatus)
+    }
+
+    // Clearing a status without DND leaves notifications alone, and so
+    // does a user's own DND schedule.
+    runSync(time.Date(2024, 6, 4, 13, 0, 0, 0, time.UTC))
+    if ends := fake.called("dnd.endSnooze"); len(ends) != 1 {
+        t.Errorf("got %d dnd.endSnooze calls after the 1:1, want still 1", len(ends))
+    }
+    if len(fake.called("dnd.endDnd")) != 0 {
+        t.Error("the sync ended a scheduled DND window")
+    }
+}
+
+// testUsers is a users.list response with two Alex Kims and a deleted user.
+func testUsers() map[string]interface{} {
+    return map[string]interface{}{"members": []map[string]interface{}{
+        {"id": "U01ALEXKIM", "name": "alex", "real_name": "Alex Kim"},
+        {"id": "U02ALEXKIM", "name": "akim", "real_name": "Alex Kim", "profile": map[string]interface{}{"display_name": "alex"}},
+        {"id": "U03SAMLEE1", "name": "sam", "real_name": "Sam Lee", "profile": map[string]interface{}{"display_name": "Sammy"}},
+        {"id": "U04GONE001", "name": "gone", "real_name": "Sam Lee", "deleted": true},
+    }}
+}
+
+func TestFindUserID(t *testing.T) {
+    fake := newFakeSlack(t, func(method string, params map[string]interface{}) map[string]interface{} {
+        if method == "users.list" {
+            return testUsers()
+        }
+        return nil
+    })
+
+    tests := []struct {
+        name    string
+        want    string
+        wantErr string
+    }{
+        {"U03SAMLEE1", "U03SAMLEE1", ""},
+        {"alex", "U01ALEXKIM", ""},
+        {"AKIM", "U02ALEXKIM", ""},
+        {"Sammy", "U03SAMLEE1", ""},
+        {"Sam Lee", "U03SAMLEE1", ""},
+        {"gone", "", "user gone not found"},
+        {"Alex Kim", "", "Alex Kim (@alex, U01ALEXKIM), Alex Kim (@akim, U02ALEXKIM)"},
+    }
+    for _, test := range tests {
+        id, err := findUserID(test.name)
+        if test.wantErr != "" {
+            if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+                t.Errorf("findUserID(%q) error = %v, want %q", test.name, err, test.wantErr)
+            }
+            continue
+        }
+        if err != nil || id != test.want {
+            t.Errorf("findUserID(%q) = %q, %v, want %q", test.name, id, err, test.want)
+        }
+    }
+    if calls := len(fake.called("users.list")); calls != 1 {
+        t.Errorf("users.list called %d times, want 1", calls)
+    }
+
+    // A later command finds handles in the cache without fetching the list.
+    fetchedUsers = nil
+    id, err := findUserID("akim")
+    if err != nil || id != "U02ALEXKIM" {
+        t.Errorf("cached findUserID(akim) = %q, %v", id, err)
+    }
+    if _, err := findUserID("Alex Kim"); err == nil {
+        t.Error("cached findUserID(Alex Kim) is not ambiguous")
+    }
+    if calls := len(fake.called("users.list")); calls != 2 {
+        t.Errorf("users.list called %d times in total, want 2", calls)
+    }
+}
+
+func TestSyncChannelMembersUsesFetchedUsers(t *testing.T) {
+    fake := newFakeSlack(t, func(method string, params map[string]interface{}) map[string]interface{} {
+        switch method {
+        case "users.list":
+            return testUsers()
+        case "conversations.members":
+            return map[string]interface{}{"members": []string{"U03SAMLEE1", "U09OTHER01"}}
+        case "auth.test":
+            return map[string]interface{}{"user_id": "U09OTHER01"}
+        }
+        return nil
+    })
+    config.ChannelCache = map[string]string{"C0GENERAL1": "general"}
+
+    membersFile := writeTestFile(t, "members.yaml", "general:\n  - alex\n  - \"@Sammy\"\n  - U02ALEXKIM\n")
+    err := syncChannelMembers(membersFile, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if calls := len(fake.called("users.list")); calls != 1 {
+        t.Errorf("users.list called %d times, want 1", calls)
+    }
+    if calls := len(fake.called("conversations.invite")); calls != 0 {
+        t.Errorf("dry run made %d invites", calls)
+    }
+
+    ambiguousFile := writeTestFile(t, "ambiguous.yaml", "general:\n  - Alex Kim\n")
+    err = syncChannelMembers(ambiguousFile, false)
+    if err == nil || !strings.Contains(err.Error(), "U01ALEXKIM") || !strings.Contains(err.Error(), "U02ALEXKIM") {
+        t.Errorf("ambiguous name error = %v", err)
+    }
+}
+
+func TestUpdateRichMessageUsesPostingToken(t *testing.T) {
+    fake := newFakeSlack(t, func(method string, params map[string]interface{}) map[string]interface{} {
+        if method == "chat.postMessage" {
+            return map[string]interface{}{"ts": "1718000000.000100"}
+        }
+        return nil
+    })
+    blocks := []map[string]interface{}{{"type": "section", "text": map[string]interface{}{"type": "mrkdwn", "text": "Deploying"}}}
+
+    ts, err := postRichMessage(currentChannelID(), "Deploying", blocks, nil, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = updateRichMessage(ts, "Deployed", blocks, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    posts, updates := fake.called("chat.postMessage"), fake.called("chat.update")
+    if len(posts) != 1 || len(updates) != 1 {
+        t.Fatalf("got %d posts and %d updates", len(posts), len(updates))
+    }
+    if updates[0].token != posts[0].token {
+        t.Errorf("update used %q, post used %q", updates[0].token, posts[0].token)
+    }
+}
+
+func TestResolveMentions(t *testing.T) {
+    fake := newFakeSlack(t, func(method string, params map[string]interface{}) map[string]interface{} {
+        if method == "users.list" {
+            return testUsers()
+        }
+        return nil
+    })
+    config.UserCache = map[string]string{"U01ALEXKIM": "Alex Kim"}
+    config.UserHandles = map[string]string{"U01ALEXKIM": "alex"}
+    config.ChannelCache = map[string]string{"C0GENERAL1": "general"}
+
+    tests := []struct {
+        text      string
+        offline   bool
+        want      string
+        wantCalls int
+    }{
+        {"@alex see #general", false, "<@U01ALEXKIM> see <#C0GENERAL1>", 0},
+        {"`@alex` and @here", false, "`@alex` and <!here>", 0},
+        {"@Sammy hi", true, "@Sammy hi", 0},
+        {"@Sammy hi", false, "<@U03SAMLEE1> hi", 1},
+        // The fetch above filled the cache.
+        {"@akim and @sam", false, "<@U02ALEXKIM> and <@U03SAMLEE1>", 0},
+        {"@nobody in #random", true, "@nobody in #random", 0},
+    }
+    for _, test := range tests {
+        fetchedUsers = nil
+        fake.mu.Lock()
+        before := len(fake.calls)
+        fake.mu.Unlock()
+        got, _ := resolveMentions(test.text, test.offline)
+        if got != test.want {
+            t.Errorf("resolveMentions(%q, %v) = %q, want %q", test.text, test.offline, got, test.want)
+        }
+        fake.mu.Lock()
+        calls := len(fake.calls) - before
+        fake.mu.Unlock()
+        if calls != test.wantCalls {
+            t.Errorf("resolveMentions(%q, %v) made %d API calls, want %d", test.text, test.offline, calls, test.wantCalls)
+        }
+    }
+}
+
+func TestFileInode(t *testing.T) {
+    if runtime.GOOS == "windows" {
+        t.Skip("no inode numbers on Windows")
+    }
+    logFile := writeTestFile(t, "app.log", "one\n")
+    before, err := os.Stat(logFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.Rename(logFile, logFile+".1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    rotated, err := os.Stat(logFile + ".1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(logFile, []byte("two\n"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    after, err := os.Stat(logFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if fileInode(before) == 0 || fileInode(before) != fileInode(rotated) {
+        t.Errorf("renamed file has inode %d, was %d", fileInode(rotated), fileInode(before))
+    }
+    if fileInode(after) == fileInode(before) {
+        t.Errorf("new file kept inode %d", fileInode(after))
+    }
+}
+
+func TestFlushesOutbox(t *testing.T) {
+    root := &cobra.Command{Use: "slack"}
+    command := func(parent *cobra.Command, name string, flags ...string) *cobra.Command {
+        cmd := &cobra.Command{Use: name, Run: func(*cobra.Command, []string) {}}
+        for _, flag := range flags {
+            cmd.Flags().Bool(flag, false, "")
+        }
+        parent.AddCommand(cmd)
+        return cmd
+    }
+    send := command(root, "send", "preview")
+    scheduler := command(root, "scheduler", "dry-run")
+    examples := command(root, "examples")
+    outbox := command(root, "outbox")
+    outboxList := command(outbox, "list")
+    status := command(root, "status")
+    presets := command(status, "presets")
+    statusSet := command(status, "set")
+
+    tests := []struct {
+        cmd  *cobra.Command
+        args []string
+        want bool
+    }{
+        {send, nil, true},
+        {send, []string{"--preview"}, false},
+        {scheduler, nil, true},
+        {scheduler, []string{"--dry-run"}, false},
+        {examples, nil, false},
+        {outboxList, nil, false},
+        {presets, nil, false},
+        {statusSet, nil, true},
+    }
+    for _, test := range tests {
+        test.cmd.Flags().Parse(test.args)
+        if got := flushesOutbox(test.cmd); got != test.want {
+            t.Errorf("flushesOutbox(%s %v) = %v, want %v", test.cmd.CommandPath(), test.args, got, test.want)
+        }
+    }
+}
+
+func TestSendDeduplicated(t *testing.T) {
+    fake := newFakeSlack(t, func(method string, params map[string]interface{}) map[string]interface{} {
+        if method == "chat.postMessage" {
+            return map[string]interface{}{"ts": "1718000000.000100"}
+        }
+        return nil
+    })
+
+    for i := 0; i < 3; i++ {
+        err := sendDeduplicated("Disk full on db1", "", "disk-db1", time.Hour, "update")
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    err := sendDeduplicated("Disk full on db1", "", "disk-db1", time.Hour, "thread")
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = sendDeduplicated("Disk full on db2", "", "disk-db2", time.Hour, "update")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    posts, updates := fake.called("chat.postMessage"), fake.called("chat.update")
+    if len(posts) != 3 || len(updates) != 2 {
+        t.Fatalf("got %d posts and %d updates, want 3 and 2", len(posts), len(updates))
+    }
+    if text := updates[1].params["text"].(string); !strings.Contains(text, "seen 3 times") || !strings.HasPrefix(text, "Disk full on db1\n") {
+        t.Errorf("second update is %q", text)
+    }
+    if posts[1].params["thread_ts"] != "1718000000.000100" || !strings.Contains(posts[1].params["text"].(string), "seen 4 times") {
+        t.Errorf("thread reply is %v", posts[1].params)
+    }
+    if posts[2].params["text"] != "Disk full on db2" {
+        t.Errorf("other key posted %v", posts[2].params)
+    }
+    if err := sendDeduplicated("x", "", "disk-db1", time.Hour, "digest"); err == nil {
+        t.Error("invalid mode accepted")
+    }
+}
+
+func TestMarkdownInline(t *testing.T) {
+    tests := []struct {
+        markdown string
+        want     string
+    }{
+        {"**bold**, *italic* and ~~gone~~", "*bold*, _italic_ and ~gone~"},
+        {"__bold__ and config_loader", "*bold* and config_loader"},
+        {"[docs](https://example.com/docs)", "<https://example.com/docs|docs>"},
+        {"[search](https://example.com/search?q=a&page=2)", "<https://example.com/search?q=a&amp;page=2|search>"},
+        {"[https://example.com/?a=1&b=2](https://example.com/?a=1&b=2)", "<https://example.com/?a=1&amp;b=2>"},
+        {"see <https://example.com/?a=1&b=2>", "see <https://example.com/?a=1&amp;b=2>"},
+        {"ping <@U01ALEXKIM> in <#C0GENERAL1>", "ping <@U01ALEXKIM> in <#C0GENERAL1>"},
+        {"a < b & c", "a &lt; b &amp; c"},
+        {"`@alex <@U01ALEXKIM> **x**`", "`@alex &lt;@U01ALEXKIM&gt; **x**`"},
+        {"run `a && b` now", "run `a &amp;&amp; b` now"},
+        {"unclosed `tick *here*", "unclosed `tick _here_"},
+    }
+    for _, test := range tests {
+        if got := markdownInline(test.markdown); got != test.want {
+            t.Errorf("markdownInline(%q) = %q, want %q", test.markdown, got, test.want)
+        }
+    }
+}
+
+func TestMarkdownToMrkdwn(t *testing.T) {
+    tests := []struct {
+        name     string
+        markdown string
+        want     string
+    }{
+        {"headers", "# Release **2.4**\n\nNotes\n===", "*Release 2.4*\n\n*Notes*"},
+        {"paragraph lines are joined", "one\ntwo  \nthree", "one two\nthree"},
+        {"nested lists", "- a\n  - b\n    - c\n1. first\n2. second", "• a\n    ◦ b\n        ▪ c\n1. first\n2. second"},
+        {"tasks", "- [ ] todo\n- [x] done", "☐ todo\n☑ done"},
+        {"table", "| Name | Count |\n|------|------:|\n| a&b | 3 |\n| [x](https://e.com) | 12 |",
+            "```\nName               Count\n-----------------  -----\na&amp;b                    3\nx (https://e.com)     12\n```"},
+        {"fenced code keeps mentions", "```\n@alex <@U01ALEXKIM> **x**\n```", "```\n@alex &lt;@U01ALEXKIM&gt; **x**\n```"},
+        {"quote and rule", "> quoted *text*\n\n---", "> quoted _text_\n\n──────────"},
+    }
+    for _, test := range tests {
+        if got := markdownToMrkdwn(test.markdown); got != test.want {
+            t.Errorf("%s: markdownToMrkdwn() = %q, want %q", test.name, got, test.want)
+        }
+    }
+}
+
+func TestMarkdownToBlocks(t *testing.T) {
+    markdown := "# Release 2.4\n\nFixed [login](https://example.com/?a=1&b=2).\n\n---\n\n## Next\n\n```\n" +
+        strings.Repeat("line of code\n", 300) + "```"
+    blocks, err := markdownToBlocks(markdown)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var types []string
+    for _, block := range blocks {
+        types = append(types, block["type"].(string))
+    }
+    if got := strings.Join(types, " "); got != "header section divider header section section" {
+        t.Fatalf("block types = %s", got)
+    }
+    if text := blocks[0]["text"].(map[string]interface{})["text"]; text != "Release 2.4" {
+        t.Errorf("header text = %q", text)
+    }
+    if text := blocks[1]["text"].(map[string]interface{})["text"]; text != "Fixed <https://example.com/?a=1&amp;b=2|login>." {
+        t.Errorf("section text = %q", text)
+    }
+    for _, block := range blocks[4:] {
+        text := block["text"].(map[string]interface{})["text"].(string)
+        if len(text) > maxSectionText || !strings.HasPrefix(text, "```") || !strings.HasSuffix(text, "```") {
+            t.Errorf("code section of %d characters is not a closed code block", len(text))
+        }
+    }
+}
+
+// historyPages serves conversations.history from messages (newest first) in
+// pages of size, using the offset as the cursor.
+func historyPages(messages []map[string]interface{}, size int) func(string, map[string]interface{}) map[string]interface{} {
+    return func(method string, params map[string]interface{}) map[string]interface{} {
+        if method != "conversations.history" {
+            return nil
+        }
+        start := 0
+        if cursor, _ := params["cursor"].(string); cursor != "" {
+            start, _ = strconv.Atoi(cursor)
+        }
+        end := start + size
+        if end > len(messages) {
+            end = len(messages)
+        }
+        response := map[string]interface{}{"messages": messages[start:end], "has_more": end < len(messages)}
+        if end < len(messages) {
+            response["response_metadata"] = map[string]interface{}{"next_cursor": strconv.Itoa(end)}
+        }
+        return response
+    }
+}
+
+func TestFetchContextTimeline(t *testing.T) {
+    // Messages 10 (newest) to 1; 9, 6 and 2 mention an error.
+    var messages []map[string]interface{}
+    for n := 10; n >= 1; n-- {
+        text := fmt.Sprintf("message %d", n)
+        if n == 9 || n == 6 || n == 2 {
+            text += " error"
+        }
+        messages = append(messages, map[string]interface{}{"ts": fmt.Sprintf("%d.000000", n), "user": "U01ALEXKIM", "text": text})
+    }
+    fake := newFakeSlack(t, historyPages(messages, 4))
+
+    tests := []struct {
+        limit, before int
+        want          string
+        wantPages     int
+    }{
+        {2, 1, "5 6 7 8 9 10", 2},
+        {2, 0, "6 7 8 9 10", 2},
+        {1, 0, "9 10", 1},
+        {3, 2, "1 2 3 4 5 6 7 8 9 10", 3},
+        {5, 0, "1 2 3 4 5 6 7 8 9 10", 3},
+    }
+    for _, test := range tests {
+        before := len(fake.called("conversations.history"))
+        timeline, err := fetchContextTimeline(test.limit, "", "", "error", false, test.before)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var got []string
+        for _, msg := range timeline {
+            got = append(got, strings.TrimSuffix(msg.Ts, ".000000"))
+        }
+        if strings.Join(got, " ") != test.want {
+            t.Errorf("limit %d, before %d: timeline %v, want %s", test.limit, test.before, got, test.want)
+        }
+        if pages := len(fake.called("conversations.history")) - before; pages != test.wantPages {
+            t.Errorf("limit %d, before %d: fetched %d pages, want %d", test.limit, test.before, pages, test.wantPages)
+        }
+    }
+}
+
+func TestRenderWithContext(t *testing.T) {
+    var timeline []SlackMessageItem
+    for n, text := range []string{"boot", "error one", "retry", "ok", "idle", "error two", "done"} {
+        timeline = append(timeline, SlackMessageItem{UserID: "U01ALEXKIM", Text: text, Ts: fmt.Sprintf("%d.000000", n+1)})
+    }
+    userCache := map[string]string{"U01ALEXKIM": "Alex Kim"}
+
+    tests := []struct {
+        before, after, limit int
+        want                 string
+    }{
+        {1, 1, 2, "c1 m2 c3 -- c5 m6 c7"},
+        {2, 0, 2, "c1 m2 -- c4 c5 m6"},
+        {0, 3, 2, "m2 c3 c4 c5 m6 c7"},
+        // Only the newest match counts; the older one is context.
+        {4, 0, 1, "c2 c3 c4 c5 m6"},
+    }
+    for _, test := range tests {
+        var got []string
+        for _, line := range renderWithContext(timeline, "", "error", test.before, test.after, test.limit, userCache) {
+            if line == "--\n" {
+                got = append(got, "--")
+                continue
+            }
+            // Lines start with a marker and the ts, whose first digit is n.
+            if ts := strings.TrimPrefix(line, "\033[1;91m>\033[0m "); ts != line {
+                got = append(got, "m"+ts[:1])
+            } else {
+                got = append(got, "c"+strings.TrimPrefix(line, "  ")[:1])
+            }
+        }
+        if strings.Join(got, " ") != test.want {
+            t.Errorf("-B %d -A %d limit %d: got %v, want %s", test.before, test.after, test.limit, got, test.want)
+        }
+    }
+}
+
+func TestSearchWorkspace(t *testing.T) {
+    fake := newFakeSlack(t, func(method string, params map[string]interface{}) map[string]interface{} {
+        if method != "search.messages" {
+            return nil
+        }
+        match := map[string]interface{}{"ts": "1718000000.000100", "text": "deploy failed", "user": "U01ALEXKIM", "channel": map[string]interface{}{"name": "ops"}}
+        return map[string]interface{}{"messages": map[string]interface{}{
+            "total":   9,
+            "paging":  map[string]interface{}{"pages": 5},
+            "matches": []interface{}{match, match},
+        }}
+    })
+    config.UserCache = map[string]string{"U01ALEXKIM": "Alex Kim"}
+
+    err := searchWorkspace("deploy in:#ops", 3, "time", true, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    calls := fake.called("search.messages")
+    if len(calls) != 2 {
+        t.Fatalf("search.messages called %d times, want 2 pages for 3 hits", len(calls))
+    }
+    params := calls[1].params
+    if params["query"] != "deploy in:#ops" || params["sort"] != "timestamp" || params["sort_dir"] != "asc" || params["count"] != "3" || params["page"] != "2" {
+        t.Errorf("second page params = %v", params)
+    }
+
+    if err := searchWorkspace("deploy", 3, "relevance", false, false); err == nil {
+        t.Error("invalid sort accepted")
+    }
+    if err := searchWorkspace("", 3, "", false, false); err == nil {
+        t.Error("empty query accepted")
+    }
+}
+
+func TestRecordRecentChannel(t *testing.T) {
+    saved := config
+    t.Cleanup(func() { config = saved })
+    config = Config{}
+
+    for i := 0; i < maxRecentChannels+2; i++ {
+        if !recordRecentChannel(fmt.Sprintf("C%09d", i)) {
+            t.Errorf("new channel %d reported no change", i)
+        }
+    }
+    if len(config.RecentChannels) != maxRecentChannels || config.RecentChannels[0] != fmt.Sprintf("C%09d", maxRecentChannels+1) {
+        t.Errorf("recent channels = %v", config.RecentChannels)
+    }
+    if recordRecentChannel(config.RecentChannels[0]) {
+        t.Error("using the most recent channel again reported a change")
+    }
+    moved := config.RecentChannels[3]
+    if !recordRecentChannel(moved) || config.RecentChannels[0] != moved || len(config.RecentChannels) != maxRecentChannels {
+        t.Errorf("moving %s to the front gave %v", moved, config.RecentChannels)
+    }
+}
+
+func TestRankChannels(t *testing.T) {
+    saved := config
+    t.Cleanup(func() { config = saved })
+    config = Config{RecentChannels: []string{"C0OPSALERT", "C0DEPLOYS1"}}
+    channels := map[string]string{
+        "C0OPS00001": "ops",
+        "C0OPSALERT": "ops-alerts",
+        "C0OPSOLD01": "ops-archive",
+        "C0DEPLOYS1": "deploys",
+        "C0RANDOM01": "random",
+        "C0DEVOPS01": "devops",
+    }
+
+    tests := []struct {
+        query string
+        want  string
+    }{
+        {"ops", "ops ops-alerts ops-archive devops"},
+        {"#OPS-AL", "ops-alerts"},
+        {"dply", "deploys"},
+        {"randm", "random"},
+        {"", "ops-alerts deploys devops ops ops-archive random"},
+    }
+    for _, test := range tests {
+        var got []string
+        for _, match := range rankChannels(test.query, channels) {
+            got = append(got, match.Name)
+        }
+        if strings.Join(got, " ") != test.want {
+            t.Errorf("rankChannels(%q) = %v, want %s", test.query, got, test.want)
+        }
+    }
+}
+
+func writeTestFile(t *testing.T, name, content string) string {
+    filePath := filepath.Join(t.TempDir(), name)
+    err := os.WriteFile(filePath, []byte(content), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return filePath
+}
+
+func decodeBlocks(t *testing.T, data string) []map[string]interface{} {
+    var blocks []map[string]interface{}
+    err := json.Unmarshal([]byte(data), &blocks)
+    if err != nil {
+        t.Fatalf("bad test JSON %s: %v", data, err)
+    }
+    return blocks
+}
+
+func TestReadBlocksFile(t *testing.T) {
+    tests := []struct {
+        name            string
+        content         string
+        wantBlocks      int
+        wantAttachments int
+        wantErr         string
+    }{
+        {"list of blocks", `[{"type": "divider"}, {"type": "section", "text": {"type": "mrkdwn", "text": "hi"}}]`, 2, 0, ""},
+        {"builder export", `{"blocks": [{"type": "divider"}], "attachments": [{"color": "#36a64f"}]}`, 1, 1, ""},
+        {"attachments only", `{"attachments": [{"text": "a"}, {"text": "b"}]}`, 0, 2, ""},
+        {"invalid JSON", `[{"type": "divider"}`, 0, 0, "invalid JSON"},
+        {"no blocks or attachments", `{"text": "hi"}`, 0, 0, "has no blocks or attachments"},
+    }
+    for _, test := range tests {
+        blocks, attachments, err := readBlocksFile(writeTestFile(t, "blocks.json", test.content))
+        if test.wantErr != "" {
+            if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+                t.Errorf("%s: error = %v, want %q", test.name, err, test.wantErr)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%s: %v", test.name, err)
+            continue
+        }
+        if len(blocks) != test.wantBlocks || len(attachments) != test.wantAttachments {
+            t.Errorf("%s: got %d blocks and %d attachments, want %d and %d", test.name, len(blocks), len(attachments), test.wantBlocks, test.wantAttachments)
+        }
+    }
+
+    _, _, err := readBlocksFile(filepath.Join(t.TempDir(), "missing.json"))
+    if err == nil {
+        t.Error("missing file: no error")
+    }
+}
+
+func TestValidateBlocks(t *testing.T) {
+    manyBlocks := "[" + strings.Repeat(`{"type": "divider"},`, maxBlocks) + `{"type": "divider"}]`
+    tests := []struct {
+        name    string
+        blocks  string
+        wantErr []string
+    }{
+        {"valid", `[
+            {"type": "header", "text": {"type": "plain_text", "text": "Deploy finished"}},
+            {"type": "section", "text": {"type": "mrkdwn", "text": "*api* is live"}, "fields": [{"type": "mrkdwn", "text": "*Env*"}]},
+            {"type": "divider"},
+            {"type": "context", "elements": [{"type": "image", "image_url": "https://example.com/a.png", "alt_text": "a"}, {"type": "mrkdwn", "text": "by ci"}]},
+            {"type": "actions", "elements": [{"type": "button", "text": {"type": "plain_text", "text": "Open"}}]},
+            {"type": "image", "image_url": "https://example.com/b.png", "alt_text": "graph"},
+            {"type": "rich_text", "elements": []}
+        ]`, nil},
+        {"section without text", `[{"type": "section"}]`, []string{"block 1 (section): text or fields is required"}},
+        {"mrkdwn header", `[{"type": "header", "text": {"type": "mrkdwn", "text": "Hi"}}]`, []string{"block 1 (header) text: type must be plain_text"}},
+        {"long header", `[{"type": "header", "text": {"type": "plain_text", "text": "` + strings.Repeat("x", maxHeaderText+1) + `"}}]`, []string{"text is 151 characters, the limit is 150"}},
+        {"long section", `[{"type": "section", "text": {"type": "mrkdwn", "text": "` + strings.Repeat("x", maxSectionText+1) + `"}}]`, []string{"the limit is 3000"}},
+        {"empty context", `[{"type": "context", "elements": []}]`, []string{"block 1 (context): elements is required"}},
+        {"image without alt text", `[{"type": "image", "image_url": "https://example.com/a.png"}]`, []string{"block 1 (image): alt_text is required"}},
+        {"duplicate block_id", `[{"type": "divider", "block_id": "a"}, {"type": "divider", "block_id": "a"}]`, []string{"block 2 (divider): duplicate block_id \"a\""}},
+        {"missing type", `[{"text": "hi"}]`, []string{"block 1 (): type is required"}},
+        {"unknown type", `[{"type": "sectoin"}]`, []string{"block 1 (sectoin): unknown block type"}},
+        {"too many blocks", manyBlocks, []string{"blocks: 51 blocks, the limit is 50"}},
+        {"every problem is reported", `[{"type": "section"}, {"type": "header", "text": {"type": "plain_text", "text": ""}}]`, []string{
+            "block 1 (section): text or fields is required",
+            "block 2 (header) text: text is required",
+        }},
+    }
+    for _, test := range tests {
+        err := validateBlocks(decodeBlocks(t, test.blocks))
+        if len(test.wantErr) == 0 {
+            if err != nil {
+                t.Errorf("%s: %v", test.name, err)
+            }
+            continue
+        }
+        if err == nil {
+            t.Errorf("%s: no error, want %q", test.name, test.wantErr)
+            continue
+        }
+        for _, want := range test.wantErr {
+            if !strings.Contains(err.Error(), want) {
+                t.Errorf("%s: error %q does not contain %q", test.name, err, want)
+            }
+        }
+    }
+}
+
+func TestBlocksFallbackText(t *testing.T) {
+    tests := []struct {
+        name        string
+        blocks      string
+        attachments string
+        want        string
+    }{
+        {"header", `[{"type": "header", "text": {"type": "plain_text", "text": "Deploy finished"}}, {"type": "section", "text": {"type": "mrkdwn", "text": "details"}}]`, `[]`, "Deploy finished"},
+        {"first line of a section", `[{"type": "divider"}, {"type": "section", "text": {"type": "mrkdwn", "text": "\n  first line\nsecond"}}]`, `[]`, "first line"},
+        {"code fence is skipped", `[{"type": "section", "text": {"type": "mrkdwn", "text": "` + "```" + `\ncode"}}]`, `[]`, "code"},
+        {"section fields", `[{"type": "section", "fields": [{"type": "mrkdwn", "text": "*Env* prod"}]}]`, `[]`, "*Env* prod"},
+        {"context", `[{"type": "context", "elements": [{"type": "image", "image_url": "x", "alt_text": "x"}, {"type": "mrkdwn", "text": "by ci"}]}]`, `[]`, "by ci"},
+        {"attachment fallback", `[]`, `[{"fallback": "Build failed", "title": "Build #12"}]`, "Build failed"},
+        {"attachment title", `[{"type": "divider"}]`, `[{"color": "danger", "title": "Build #12"}]`, "Build #12"},
+        {"nothing to use", `[{"type": "divider"}]`, `[{"color": "good"}]`, "Message"},
+    }
+    for _, test := range tests {
+        got := blocksFallbackText(decodeBlocks(t, test.blocks), decodeBlocks(t, test.attachments))
+        if got != test.want {
+            t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+        }
+    }
+}
+
+func TestReadRichContent(t *testing.T) {
+    blocksFile := writeTestFile(t, "blocks.json", `[{"type": "header", "text": {"type": "plain_text", "text": "Release 2.4"}}]`)
+    builderFile := writeTestFile(t, "builder.json", `{"blocks": [{"type": "divider"}], "attachments": [{"fallback": "from builder"}]}`)
+    attachmentsFile := writeTestFile(t, "attachments.json", `[{"fallback": "Build failed", "color": "danger"}]`)
+    invalidFile := writeTestFile(t, "invalid.json", `[{"type": "header", "text": {"type": "mrkdwn", "text": "Release"}}]`)
+    tooManyFile := writeTestFile(t, "many.json", "["+strings.Repeat(`{"text": "a"},`, maxAttachments)+`{"text": "a"}]`)
+
+    tests := []struct {
+        name            string
+        text            string
+        blocksFile      string
+        attachmentsFile string
+        wantText        string
+        wantBlocks      int
+        wantAttachments int
+        wantErr         string
+    }{
+        {"fallback from blocks", "", blocksFile, "", "Release 2.4", 1, 0, ""},
+        {"text is kept", "Release notes", blocksFile, "", "Release notes", 1, 0, ""},
+        {"attachments file", "", "", attachmentsFile, "Build failed", 0, 1, ""},
+        {"attachments are combined", "", builderFile, attachmentsFile, "from builder", 1, 2, ""},
+        {"invalid blocks", "", invalidFile, "", "", 0, 0, "type must be plain_text"},
+        {"too many attachments", "", "", tooManyFile, "", 0, 0, "21 attachments, the limit is 20"},
+        {"missing file", "", filepath.Join(t.TempDir(), "missing.json"), "", "", 0, 0, "missing.json"},
+    }
+    for _, test := range tests {
+        text, blocks, attachments, err := readRichContent(test.text, test.blocksFile, test.attachmentsFile)
+        if test.wantErr != "" {
+            if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+                t.Errorf("%s: error = %v, want %q", test.name, err, test.wantErr)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%s: %v", test.name, err)
+            continue
+        }
+        if text != test.wantText || len(blocks) != test.wantBlocks || len(attachments) != test.wantAttachments {
+            t.Errorf("%s: got %q with %d blocks and %d attachments, want %q with %d and %d", test.name,
+                text, len(blocks), len(attachments), test.wantText, test.wantBlocks, test.wantAttachments)
+        }
+    }
+}